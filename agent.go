@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// DefaultAgentLifetime is how long a key pushed into the agent stays there
+const DefaultAgentLifetime = 8 * time.Hour
+
+// windowsAgentPipe is where the Windows OpenSSH agent service listens
+const windowsAgentPipe = `\\.\pipe\openssh-ssh-agent`
+
+// SSHAgent is a connection to the running ssh-agent
+type SSHAgent struct {
+	agent.ExtendedAgent
+	conn io.Closer
+}
+
+// OpenAgent connects to the agent at $SSH_AUTH_SOCK, or on Windows to the OpenSSH
+// agent service when that is unset
+func OpenAgent() (*SSHAgent, error) {
+	var conn io.ReadWriteCloser
+	var err error
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	switch {
+	case runtime.GOOS == "windows" && (sock == "" || strings.HasPrefix(sock, `\\.\pipe\`)):
+		if sock == "" {
+			sock = windowsAgentPipe
+		}
+		// Named pipes open like files; the agent protocol is strictly request/response
+		conn, err = os.OpenFile(sock, os.O_RDWR, 0)
+	case sock == "":
+		return nil, errors.New("no ssh-agent found: SSH_AUTH_SOCK is not set")
+	default:
+		conn, err = net.Dial("unix", sock)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh-agent at %s: %w", sock, err)
+	}
+
+	return &SSHAgent{ExtendedAgent: agent.NewClient(conn), conn: conn}, nil
+}
+
+// Close disconnects from the agent; the keys it holds are unaffected
+func (a *SSHAgent) Close() error {
+	return a.conn.Close()
+}
+
+// ParseAgentLifetime parses a lifetime such as "30m" or "8h"; empty means DefaultAgentLifetime
+func ParseAgentLifetime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultAgentLifetime, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid agent lifetime %q: %w", s, err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("agent lifetime must be at least one second")
+	}
+	return d, nil
+}
+
+// AddToAgent loads the P12 private key into the agent, removed again by the agent
+// after lifetime. If cert is set, the key is also added together with the certificate.
+func AddToAgent(keyring agent.Agent, info *P12Info, cert *ssh.Certificate, lifetime time.Duration) error {
+	comment := info.UPN
+	if comment == "" {
+		comment = info.CommonName
+	}
+	comment += " (" + AppName + ")"
+
+	key := agent.AddedKey{
+		PrivateKey:   info.PrivateKey,
+		Comment:      comment,
+		LifetimeSecs: uint32(lifetime / time.Second),
+	}
+	if err := keyring.Add(key); err != nil {
+		return fmt.Errorf("failed to add key to ssh-agent: %w", err)
+	}
+
+	if cert != nil {
+		key.Certificate = cert
+		if err := keyring.Add(key); err != nil {
+			return fmt.Errorf("failed to add SSH certificate to ssh-agent: %w", err)
+		}
+	}
+	return nil
+}
+
+// forwardAgent serves the local agent to the server for the life of client, like
+// ssh -A: the server exposes it to the SSH session opened here. That session runs no
+// command and the tunnel itself never uses the agent, so it only serves sessions and
+// processes on the server outside the app that find the socket sshd creates. The keys
+// stay usable by the server, and its administrators, until the connection closes.
+func forwardAgent(client *ssh.Client, keyring agent.Agent) error {
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		return err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open session: %w", err)
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		session.Close()
+		return fmt.Errorf("server refused agent forwarding: %w", err)
+	}
+
+	// The session stays open without a command; it ends with the connection
+	go func() {
+		client.Wait()
+		session.Close()
+	}()
+	return nil
+}
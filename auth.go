@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// interactiveAuthTries is how often a rejected password or challenge answer may be
+// re-entered, as with OpenSSH's NumberOfPasswordPrompts
+const interactiveAuthTries = 3
+
+// errAuthCancelled aborts authentication when the user dismisses a prompt
+var errAuthCancelled = errors.New("authentication cancelled by user")
+
+// authMethods offers the certificate key (or the agent's keys) first, then keyboard-interactive and password
+// for servers that demand a second factor (AuthenticationMethods publickey,keyboard-interactive).
+// The client only tries methods the server still lists, so nothing is prompted for when
+// the key alone is accepted.
+func authMethods(addr, user string, signer ssh.Signer, keyring agent.Agent, prompter Prompter) []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if signer != nil {
+		methods = append(methods, ssh.PublicKeys(signer))
+	} else if keyring != nil {
+		methods = append(methods, ssh.PublicKeysCallback(keyring.Signers))
+	}
+	if prompter == nil {
+		return methods
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	target := user + "@" + host
+
+	return append(methods,
+		ssh.RetryableAuthMethod(ssh.KeyboardInteractive(keyboardInteractive(target, prompter)), interactiveAuthTries),
+		ssh.RetryableAuthMethod(ssh.PasswordCallback(passwordPrompt(target, prompter)), interactiveAuthTries),
+	)
+}
+
+// keyboardInteractive answers each challenge round (typically a TOTP or push prompt)
+// through the prompter
+func keyboardInteractive(target string, prompter Prompter) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if len(questions) == 0 {
+			// Informational round with nothing to answer
+			return nil, nil
+		}
+
+		title := sanitizePrompt(name)
+		if title == "" {
+			title = "SSH Authentication"
+		}
+		message := "Verification for " + target
+		if instruction = sanitizePrompt(instruction); instruction != "" {
+			message += "\n" + instruction
+		}
+
+		clean := make([]string, len(questions))
+		for i, q := range questions {
+			clean[i] = sanitizePrompt(q)
+		}
+
+		answers, ok := prompter.Challenge(title, message, clean, echos)
+		if !ok {
+			return nil, errAuthCancelled
+		}
+		return answers, nil
+	}
+}
+
+// passwordPrompt asks for the account password when the server requires "password" auth
+func passwordPrompt(target string, prompter Prompter) func() (string, error) {
+	return func() (string, error) {
+		answers, ok := prompter.Challenge("SSH Password", "Password for "+target, []string{"Password:"}, []bool{false})
+		if !ok {
+			return "", errAuthCancelled
+		}
+		return answers[0], nil
+	}
+}
+
+// sanitizePrompt strips control characters from server-supplied text before it is
+// shown, so a hostile server cannot inject terminal escape sequences
+func sanitizePrompt(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TrustedRootsFile is the PEM bundle, next to config.json, of CAs trusted to issue P12 certificates
+const TrustedRootsFile = "trusted_roots.pem"
+
+// errNoTrustedRoots means the trust store is missing or empty, so the chain cannot be judged
+var errNoTrustedRoots = errors.New("no trusted root CAs configured")
+
+// GetTrustedRootsPath returns the trust store path; the file need not exist
+func GetTrustedRootsPath() (string, error) {
+	cfgPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(cfgPath), TrustedRootsFile), nil
+}
+
+// ParseCertificates reads one or more PEM certificates, or a single DER certificate
+func ParseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		cert, err := x509.ParseCertificate(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		return []*x509.Certificate{cert}, nil
+	}
+
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+// LoadTrustedRoots reads the trust store; a missing file yields no certificates
+func LoadTrustedRoots(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, nil
+	}
+	certs, err := ParseCertificates(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return certs, nil
+}
+
+// AddTrustedRoot appends a CA certificate to the trust store, unless it is already there
+func AddTrustedRoot(cert *x509.Certificate) error {
+	if !cert.IsCA {
+		return fmt.Errorf("%s is not a CA certificate", certName(cert))
+	}
+
+	path, err := GetTrustedRootsPath()
+	if err != nil {
+		return err
+	}
+	roots, err := LoadTrustedRoots(path)
+	if err != nil {
+		return err
+	}
+	for _, r := range roots {
+		if r.Equal(cert) {
+			return fmt.Errorf("%s is already trusted", certName(cert))
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	header := fmt.Sprintf("# %s\n# SHA-256 %s\n", cert.Subject, CertFingerprint(cert))
+	if _, err := f.WriteString(header); err != nil {
+		return fmt.Errorf("failed to update %s: %w", TrustedRootsFile, err)
+	}
+	if err := pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}); err != nil {
+		return fmt.Errorf("failed to update %s: %w", TrustedRootsFile, err)
+	}
+	return nil
+}
+
+// CertFingerprint is the colon-separated SHA-256 of the DER certificate, as shown by
+// Windows and openssl x509 -fingerprint -sha256
+func CertFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	hexSum := strings.ToUpper(hex.EncodeToString(sum[:]))
+	parts := make([]string, 0, len(sum))
+	for i := 0; i < len(hexSum); i += 2 {
+		parts = append(parts, hexSum[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// certName is the CN, or the full subject for certificates without one
+func certName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return cert.Subject.String()
+}
+
+// PublicKeyDescription names the certificate key's algorithm and size, e.g. "RSA 2048"
+func PublicKeyDescription(cert *x509.Certificate) string {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA %d", k.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA " + k.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return cert.PublicKeyAlgorithm.String()
+}
+
+// ChainStatus is the outcome of validating the P12 certificate chain
+type ChainStatus struct {
+	Path      []*x509.Certificate // leaf first, as far as it could be built
+	RootsPath string
+	Roots     int   // certificates in the trust store
+	Trusted   bool  // Path ends at a trusted root
+	Err       error // why the chain is not trusted
+	UsageErr  error // why the leaf is unsuitable for client authentication
+}
+
+// VerifyP12Chain validates the P12 certificate against the trust store in the config dir
+func VerifyP12Chain(info *P12Info) *ChainStatus {
+	path, err := GetTrustedRootsPath()
+	if err != nil {
+		return &ChainStatus{Path: buildPath(info.Certificate, info.Chain), Err: err}
+	}
+	roots, err := LoadTrustedRoots(path)
+	if err != nil {
+		return &ChainStatus{Path: buildPath(info.Certificate, info.Chain), RootsPath: path, Err: err}
+	}
+	status := verifyChain(info, roots, time.Now())
+	status.RootsPath = path
+	return status
+}
+
+// verifyChain builds the path from the leaf through the P12's CA certificates and
+// checks it ends at one of roots, then checks the leaf may be used for client auth
+func verifyChain(info *P12Info, roots []*x509.Certificate, now time.Time) *ChainStatus {
+	leaf := info.Certificate
+	status := &ChainStatus{Roots: len(roots), UsageErr: checkClientAuthUsage(leaf)}
+
+	if len(roots) == 0 {
+		status.Path = buildPath(leaf, info.Chain)
+		status.Err = errNoTrustedRoots
+		return status
+	}
+
+	rootPool := x509.NewCertPool()
+	for _, r := range roots {
+		rootPool.AddCert(r)
+	}
+	intermediates := x509.NewCertPool()
+	for _, c := range info.Chain {
+		intermediates.AddCert(c)
+	}
+
+	// EKU is checked on the leaf alone by checkClientAuthUsage, so a CA restricting
+	// its EKUs does not read as an untrusted chain
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		status.Path = buildPath(leaf, append(append([]*x509.Certificate(nil), info.Chain...), roots...))
+		status.Err = err
+		return status
+	}
+
+	status.Path = chains[0]
+	status.Trusted = true
+	return status
+}
+
+// buildPath follows issuers from leaf through pool by signature, without judging trust
+func buildPath(leaf *x509.Certificate, pool []*x509.Certificate) []*x509.Certificate {
+	path := []*x509.Certificate{leaf}
+	for cur := leaf; !isSelfSigned(cur); {
+		var next *x509.Certificate
+		for _, c := range pool {
+			if inPath(path, c) || !bytes.Equal(c.RawSubject, cur.RawIssuer) {
+				continue
+			}
+			if cur.CheckSignatureFrom(c) == nil {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+		path = append(path, next)
+		cur = next
+	}
+	return path
+}
+
+func inPath(path []*x509.Certificate, cert *x509.Certificate) bool {
+	for _, c := range path {
+		if c.Equal(cert) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+}
+
+// checkClientAuthUsage checks the leaf's key usage allows signing and its extended
+// key usage, when present, includes TLS client authentication
+func checkClientAuthUsage(cert *x509.Certificate) error {
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return errors.New("key usage does not include digital signature")
+	}
+	if len(cert.ExtKeyUsage) == 0 && len(cert.UnknownExtKeyUsage) == 0 {
+		return nil
+	}
+	for _, u := range cert.ExtKeyUsage {
+		if u == x509.ExtKeyUsageClientAuth || u == x509.ExtKeyUsageAny {
+			return nil
+		}
+	}
+	return errors.New("extended key usage does not include client authentication")
+}
+
+// PathString renders the chain as "leaf -> intermediate -> root"
+func (s *ChainStatus) PathString() string {
+	names := make([]string, len(s.Path))
+	for i, c := range s.Path {
+		names[i] = certName(c)
+	}
+	return strings.Join(names, " -> ")
+}
+
+// Result is a one-line verdict on trust and usage
+func (s *ChainStatus) Result() string {
+	var msg string
+	switch {
+	case s.Trusted:
+		msg = fmt.Sprintf("trusted (verified against %s)", TrustedRootsFile)
+	case errors.Is(s.Err, errNoTrustedRoots):
+		msg = fmt.Sprintf("not verified: no trusted root CAs in %s", s.RootsPath)
+	default:
+		msg = fmt.Sprintf("NOT trusted: %v", s.Err)
+	}
+	if s.UsageErr != nil {
+		msg += fmt.Sprintf("; not valid for client authentication: %v", s.UsageErr)
+	}
+	return msg
+}
+
+// Warning is a short note for the status bar, empty when the chain is trusted or
+// there is no trust store to judge it by
+func (s *ChainStatus) Warning() string {
+	switch {
+	case s.UsageErr != nil:
+		return "not valid for client auth"
+	case !s.Trusted && !errors.Is(s.Err, errNoTrustedRoots):
+		return "chain NOT trusted"
+	}
+	return ""
+}
+
+// Report lists the chain and verification result for the activity log
+func (s *ChainStatus) Report() []string {
+	return []string{
+		"Chain: " + s.PathString(),
+		"Chain Verification: " + s.Result(),
+	}
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "Digital Signature"},
+	{x509.KeyUsageContentCommitment, "Non Repudiation"},
+	{x509.KeyUsageKeyEncipherment, "Key Encipherment"},
+	{x509.KeyUsageDataEncipherment, "Data Encipherment"},
+	{x509.KeyUsageKeyAgreement, "Key Agreement"},
+	{x509.KeyUsageCertSign, "Certificate Sign"},
+	{x509.KeyUsageCRLSign, "CRL Sign"},
+	{x509.KeyUsageEncipherOnly, "Encipher Only"},
+	{x509.KeyUsageDecipherOnly, "Decipher Only"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "Any",
+	x509.ExtKeyUsageServerAuth:      "Server Authentication",
+	x509.ExtKeyUsageClientAuth:      "Client Authentication",
+	x509.ExtKeyUsageCodeSigning:     "Code Signing",
+	x509.ExtKeyUsageEmailProtection: "Email Protection",
+	x509.ExtKeyUsageTimeStamping:    "Time Stamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSP Signing",
+}
+
+// KeyUsages names the certificate's key usages and extended key usages; unknown
+// extended usages are given by OID (e.g. Microsoft smart card logon)
+func KeyUsages(cert *x509.Certificate) (usages, extUsages []string) {
+	for _, u := range keyUsageNames {
+		if cert.KeyUsage&u.usage != 0 {
+			usages = append(usages, u.name)
+		}
+	}
+	for _, u := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("EKU %d", u)
+		}
+		extUsages = append(extUsages, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		extUsages = append(extUsages, oid.String())
+	}
+	return usages, extUsages
+}
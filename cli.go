@@ -0,0 +1,913 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Exit codes of the headless command-line mode
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// Environment variables read by the command-line mode
+const (
+	PasswordEnv     = "RDPSSH_P12_PASSWORD"  // certificate password when no other source is given
+	CAPassphraseEnv = "RDPSSH_CA_PASSPHRASE" // passphrase of an encrypted user CA key
+)
+
+// cliCommand is one headless subcommand. run returns the value reported as "result".
+type cliCommand struct {
+	name    string
+	summary string
+	run     func(args []string) (any, error)
+}
+
+var cliCommands []cliCommand
+
+func init() {
+	cliCommands = []cliCommand{
+		{"connect", "Open the tunnel for a profile and launch the RDP client", cliConnect},
+		{"test", "Test SSH connectivity and authentication for a profile", cliTest},
+		{"export-key", "Export the certificate key in OpenSSH format", cliExportKey},
+		{"cert-info", "Show details of the P12 certificate and SSH certificate", cliCertInfo},
+		{"profiles", "List saved connection profiles", cliProfiles},
+		{"import-rdp", "Create profiles from .rdp files", cliImportRDP},
+		{"import-ssh-config", "Create profiles from Host entries in ~/.ssh/config", cliImportSSHConfig},
+		{"agent-add", "Add the certificate key to the running ssh-agent", cliAgentAdd},
+		{"help", "Show this help", cliHelp},
+	}
+}
+
+// usageError marks bad command-line arguments (exit code 2)
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+// cliResult is the JSON document written to stdout when a command finishes
+type cliResult struct {
+	Command string `json:"command"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+	Result  any    `json:"result,omitempty"`
+}
+
+// isCLICommand reports whether the first argument selects the command-line mode
+func isCLICommand(arg string) bool {
+	if arg == "-h" || arg == "--help" {
+		return true
+	}
+	for _, c := range cliCommands {
+		if c.name == arg {
+			return true
+		}
+	}
+	return false
+}
+
+// runCLI runs a subcommand without initializing the GUI and returns the process exit code.
+// The result is printed to stdout as JSON; progress is logged to stderr.
+func runCLI(args []string) int {
+	name := args[0]
+	if name == "-h" || name == "--help" {
+		name = "help"
+	}
+
+	log.SetOutput(os.Stderr)
+	log.SetFlags(log.LstdFlags)
+
+	var cmd cliCommand
+	for _, c := range cliCommands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+
+	result, err := cmd.run(args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	out := cliResult{Command: name, OK: err == nil, Result: result}
+	if err != nil {
+		out.Error = err.Error()
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(out)
+
+	var usage *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usage):
+		return exitUsage
+	default:
+		return exitFailure
+	}
+}
+
+// cliOptions are the flags shared by the subcommands that load a profile
+type cliOptions struct {
+	profile          string
+	p12Path          string
+	passwordStdin    bool
+	passwordEnv      string
+	passwordFile     string
+	acceptNewHostKey bool
+	proxy            string
+	useAgent         bool
+	forwardAgent     bool
+	quiet            bool
+
+	// needP12 loads the P12 file even for profiles that authenticate with the agent
+	needP12 bool
+}
+
+func newCLIFlags(name string, o *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	fs.StringVar(&o.profile, "profile", "", "profile name (default: the last selected profile)")
+	fs.StringVar(&o.p12Path, "p12", "", "P12/PFX file, overriding the profile's")
+	fs.BoolVar(&o.passwordStdin, "password-stdin", false, "read the certificate password from the first line of stdin")
+	fs.StringVar(&o.passwordEnv, "password-env", "", "read the certificate password from this environment variable (default "+PasswordEnv+")")
+	fs.StringVar(&o.passwordFile, "password-file", "", "read the certificate password from this file")
+	fs.BoolVar(&o.acceptNewHostKey, "accept-new-host-key", false, "trust and save unknown host keys without asking")
+	fs.BoolVar(&o.useAgent, "agent", false, "authenticate with the keys in the running ssh-agent instead of the P12 file")
+	fs.BoolVar(&o.forwardAgent, "forward-agent", false, "forward the local ssh-agent to the server for sessions started there outside rdpssh; the server can use its keys while connected")
+	fs.StringVar(&o.proxy, "proxy", "", "outbound proxy for the SSH connection, overriding the config: a http://, https://, socks5:// URL, env or direct")
+	fs.BoolVar(&o.quiet, "quiet", false, "do not log progress to stderr")
+	return fs
+}
+
+func parseCLIFlags(fs *flag.FlagSet, o *cliOptions, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{err.Error()}
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fmt.Sprintf("unexpected argument %q", fs.Arg(0))}
+	}
+	if o.quiet {
+		log.SetOutput(io.Discard)
+	}
+	return nil
+}
+
+// stdinIsTerminal reports whether prompts can be answered interactively
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+var stdinReader = bufio.NewReader(os.Stdin)
+
+func readStdinLine() (string, error) {
+	line, err := stdinReader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readP12Password returns the certificate password from the first configured source:
+// --password-stdin, --password-file, --password-env, $RDPSSH_P12_PASSWORD, or a
+// terminal prompt
+func readP12Password(o *cliOptions) (string, error) {
+	sources := 0
+	for _, set := range []bool{o.passwordStdin, o.passwordFile != "", o.passwordEnv != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return "", &usageError{"use only one of --password-stdin, --password-file and --password-env"}
+	}
+
+	switch {
+	case o.passwordStdin:
+		pass, err := readStdinLine()
+		if err != nil {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		return pass, nil
+	case o.passwordFile != "":
+		data, err := os.ReadFile(o.passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case o.passwordEnv != "":
+		pass, ok := os.LookupEnv(o.passwordEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", o.passwordEnv)
+		}
+		return pass, nil
+	}
+
+	if pass, ok := os.LookupEnv(PasswordEnv); ok {
+		return pass, nil
+	}
+	if stdinIsTerminal() {
+		return promptSecret("Certificate password: ")
+	}
+	return "", fmt.Errorf("certificate password required (use --password-stdin, --password-file, --password-env or %s)", PasswordEnv)
+}
+
+// promptSecret reads a line from the terminal without echoing it
+func promptSecret(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	data, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cliPrompter answers handshake questions on the terminal. Without a terminal (or when
+// stdin carries the password) unknown host keys are refused unless acceptNew is set.
+type cliPrompter struct {
+	acceptNew   bool
+	interactive bool
+}
+
+func (p *cliPrompter) ConfirmHostKey(hostname string, remote net.Addr, key ssh.PublicKey) bool {
+	fingerprint := ssh.FingerprintSHA256(key)
+	log.Printf("Unknown host key for %s (%s): %s %s", hostname, remote, key.Type(), fingerprint)
+
+	switch {
+	case p.acceptNew:
+		log.Printf("Host key for %s trusted (--accept-new-host-key) and saved to known_hosts.", hostname)
+		return true
+	case !p.interactive:
+		log.Printf("Host key for %s rejected; rerun with --accept-new-host-key or from a terminal to trust it.", hostname)
+		return false
+	}
+
+	fmt.Fprintf(os.Stderr, "The authenticity of host %s (%s) can't be established.\n%s key fingerprint is %s.\nTrust this host and add it to known_hosts (yes/no)? ",
+		hostname, remote, key.Type(), fingerprint)
+	answer, err := readStdinLine()
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	ok := answer == "yes" || answer == "y"
+	if ok {
+		log.Printf("Host key for %s trusted and saved to known_hosts.", hostname)
+	} else {
+		log.Printf("Host key for %s rejected by user.", hostname)
+	}
+	return ok
+}
+
+func (p *cliPrompter) Secret(title, message string) (string, bool) {
+	if !p.interactive {
+		return "", false
+	}
+	value, err := promptSecret(message + ": ")
+	if err != nil {
+		return "", false
+	}
+	return value, true
+}
+
+func (p *cliPrompter) Challenge(title, message string, questions []string, echos []bool) ([]string, bool) {
+	if !p.interactive {
+		log.Printf("%s: %s needs an answer on the terminal; run from a terminal to authenticate.", title, message)
+		return nil, false
+	}
+
+	fmt.Fprintf(os.Stderr, "%s\n", message)
+	answers := make([]string, len(questions))
+	for i, q := range questions {
+		var err error
+		if i < len(echos) && echos[i] {
+			fmt.Fprint(os.Stderr, q+" ")
+			answers[i], err = readStdinLine()
+		} else {
+			answers[i], err = promptSecret(q + " ")
+		}
+		if err != nil {
+			return nil, false
+		}
+	}
+	return answers, true
+}
+
+// cliSession is a profile with its credentials loaded, ready to connect
+type cliSession struct {
+	cfg      *Config
+	profile  *Profile
+	info     *P12Info
+	proxy    string
+	prompter *cliPrompter
+}
+
+// loadCLISession resolves the profile and decodes its P12 file, unless the profile
+// authenticates with the ssh-agent
+func loadCLISession(o *cliOptions) (*cliSession, error) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	p := cfg.ActiveProfile()
+	if o.profile != "" {
+		if p = cfg.Profile(o.profile); p == nil {
+			return nil, fmt.Errorf("profile %q not found", o.profile)
+		}
+	}
+	// Work on a copy so overrides never leak into the saved config
+	cp := *p
+	p = &cp
+	if o.p12Path != "" {
+		p.P12Path = o.p12Path
+	}
+	p.UseAgent = p.UseAgent || o.useAgent
+	p.ForwardAgent = p.ForwardAgent || o.forwardAgent
+	withP12 := !p.UseAgent || o.needP12 || p.UsernameRule != UsernameManual
+	if withP12 && p.P12Path == "" {
+		return nil, fmt.Errorf("profile %q has no P12 file; set one with --p12", p.Name)
+	}
+
+	proxySetting := cfg.Proxy
+	switch o.proxy {
+	case "":
+	case "direct":
+		proxySetting = ""
+	default:
+		if _, err := ParseProxy(o.proxy); err != nil {
+			return nil, &usageError{err.Error()}
+		}
+		proxySetting = o.proxy
+	}
+
+	var info *P12Info
+	if withP12 {
+		password, err := readP12Password(o)
+		if err != nil {
+			return nil, err
+		}
+		if info, err = ParseP12(p.P12Path, password); err != nil {
+			return nil, err
+		}
+		log.Printf("Certificate Loaded: %s", info.Certificate.Subject)
+		for _, line := range VerifyP12Chain(info).Report() {
+			log.Print(line)
+		}
+		if notice := cfg.ExpiryNotice(info.Certificate, time.Now()); notice != "" {
+			log.Printf("Warning: %s", notice)
+		}
+	}
+
+	return &cliSession{
+		cfg:     cfg,
+		profile: p,
+		info:    info,
+		proxy:   proxySetting,
+		prompter: &cliPrompter{
+			acceptNew:   o.acceptNewHostKey,
+			interactive: stdinIsTerminal() && !o.passwordStdin,
+		},
+	}, nil
+}
+
+// loadCA reads the user CA key, taking the passphrase from $RDPSSH_CA_PASSPHRASE or the terminal
+func (s *cliSession) loadCA() (ssh.Signer, error) {
+	signer, err := LoadCAKey(s.cfg.UserCAKeyPath, nil)
+	if err != ErrCAPassphraseRequired {
+		return signer, err
+	}
+	pass, ok := os.LookupEnv(CAPassphraseEnv)
+	if !ok {
+		if pass, ok = s.prompter.Secret("SSH User CA", "Passphrase for "+s.cfg.UserCAKeyPath); !ok {
+			return nil, fmt.Errorf("CA key is passphrase protected; set %s", CAPassphraseEnv)
+		}
+	}
+	return LoadCAKey(s.cfg.UserCAKeyPath, []byte(pass))
+}
+
+func (s *cliSession) userCert() (*ssh.Certificate, error) {
+	return ResolveUserCertificate(s.cfg, s.profile, s.info, s.loadCA, func(msg string) { log.Print(msg) })
+}
+
+func (s *cliSession) signer() (ssh.Signer, error) {
+	if s.profile.UseAgent {
+		return nil, nil
+	}
+	if err := CheckCertValidity(s.info.Certificate, time.Now()); err != nil {
+		return nil, err
+	}
+	if s.cfg.Revocation.Enabled {
+		status := CheckP12Revocation(s.cfg.Revocation, s.info)
+		log.Printf("Revocation: %s", status)
+		if err := status.Enforce(s.cfg.Revocation); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := s.userCert()
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(s.info, cert)
+}
+
+func (s *cliSession) connectOptions() (ConnectOptions, error) {
+	p := s.profile
+	if p.RemoteHost == "" || (p.RemoteUser == "" && !p.UseSSHConfig && p.UsernameRule == UsernameManual) {
+		return ConnectOptions{}, fmt.Errorf("profile %q needs a remote host and SSH username", p.Name)
+	}
+	user, err := p.SSHUser(s.info)
+	if err != nil {
+		return ConnectOptions{}, err
+	}
+	if p.UsernameRule != UsernameManual {
+		log.Printf("SSH username %q from certificate (%s)", user, UsernameRuleLabel(p.UsernameRule))
+	}
+	signer, err := s.signer()
+	if err != nil {
+		return ConnectOptions{}, err
+	}
+	return ConnectOptions{
+		Host:          p.RemoteHost,
+		User:          user,
+		JumpHosts:     p.JumpHosts,
+		Signer:        signer,
+		Prompter:      s.prompter,
+		RefreshSigner: s.signer,
+		UseSSHConfig:  p.UseSSHConfig,
+		Proxy:         s.proxy,
+		UseAgent:      p.UseAgent,
+		ForwardAgent:  p.ForwardAgent,
+	}, nil
+}
+
+func cliTest(args []string) (any, error) {
+	var o cliOptions
+	if err := parseCLIFlags(newCLIFlags("test", &o), &o, args); err != nil {
+		return nil, err
+	}
+	s, err := loadCLISession(&o)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := s.connectOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := TestConnection(opts, func(m string) { log.Print(m) })
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"profile":    s.profile.Name,
+		"host":       opts.Host,
+		"user":       opts.User,
+		"jump_hosts": len(opts.JumpHosts),
+		"message":    msg,
+	}, nil
+}
+
+func cliConnect(args []string) (any, error) {
+	var o cliOptions
+	fs := newCLIFlags("connect", &o)
+	rdpClient := fs.String("rdp-client", "", "RDP client, overriding the profile's: auto, mstsc, freerdp, remmina, custom or none (tunnel only)")
+	if err := parseCLIFlags(fs, &o, args); err != nil {
+		return nil, err
+	}
+	s, err := loadCLISession(&o)
+	if err != nil {
+		return nil, err
+	}
+	switch *rdpClient {
+	case "":
+	case "auto":
+		s.profile.RDPClient = RDPClientAuto
+	default:
+		s.profile.RDPClient = *rdpClient
+	}
+	launcher, err := s.profile.Launcher()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := s.connectOptions()
+	if err != nil {
+		return nil, err
+	}
+	p := s.profile
+	target, err := NormalizeRDPTarget(p.RDPTarget)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ctrl+C or SIGTERM closes the tunnel cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var counters []*ForwardCounter
+	tunnel := TunnelOptions{
+		LocalPort:    p.LocalPort,
+		RemoteTarget: target,
+		OnStatus:     func(status string) { log.Printf("Status: %s", status) },
+		Launcher:     launcher,
+		Title:        p.Name,
+		Display:      p.Display,
+		Forwards:     p.Forwards,
+		SOCKS:        p.SOCKS,
+		OnForwards:   func(c []*ForwardCounter) { counters = c },
+	}
+	onReady := func() { log.Print("Tunnel Ready. RDP Client Launched.") }
+	if p.TunnelOnly() {
+		onReady = func() { log.Printf("Tunnel Ready at localhost:%s. Press Ctrl+C to disconnect.", p.LocalPort) }
+	}
+
+	started := time.Now()
+	err = StartTunnel(ctx, opts, tunnel, func(m string) { log.Print(m) }, onReady)
+	if ctx.Err() != nil {
+		log.Print("Disconnect requested by signal.")
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type forwardResult struct {
+		Forward  string `json:"forward"`
+		Sent     int64  `json:"bytes_sent"`
+		Received int64  `json:"bytes_received"`
+	}
+	var forwards []forwardResult
+	for _, c := range counters {
+		sent, received := c.Bytes()
+		forwards = append(forwards, forwardResult{c.Label, sent, received})
+	}
+	return map[string]any{
+		"profile":  p.Name,
+		"host":     opts.Host,
+		"local":    "localhost:" + p.LocalPort,
+		"target":   target,
+		"client":   launcher.Name(),
+		"forwards": forwards,
+		"duration": time.Since(started).Round(time.Second).String(),
+	}, nil
+}
+
+func cliExportKey(args []string) (any, error) {
+	o := cliOptions{needP12: true}
+	fs := newCLIFlags("export-key", &o)
+	public := fs.Bool("public", false, "export the public key (authorized_keys format) instead of the private key")
+	out := fs.String("out", "", "write the key to this file instead of the JSON result")
+	if err := parseCLIFlags(fs, &o, args); err != nil {
+		return nil, err
+	}
+	s, err := loadCLISession(&o)
+	if err != nil {
+		return nil, err
+	}
+
+	kind := "private"
+	var data []byte
+	if *public {
+		kind = "public"
+		data, err = ExportPublicKey(s.info.PrivateKey)
+	} else {
+		data, err = ExportPrivateKey(s.info.PrivateKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("export failed: %w", err)
+	}
+
+	signer, err := NewSigner(s.info, nil)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]any{
+		"type":        kind,
+		"fingerprint": ssh.FingerprintSHA256(signer.PublicKey()),
+	}
+	if *out == "" {
+		result["key"] = string(data)
+		return result, nil
+	}
+
+	mode := os.FileMode(0600)
+	if *public {
+		mode = 0644
+	}
+	if err := os.WriteFile(*out, data, mode); err != nil {
+		return nil, err
+	}
+	log.Printf("Key exported to %s", *out)
+	result["path"] = *out
+	return result, nil
+}
+
+func cliCertInfo(args []string) (any, error) {
+	o := cliOptions{needP12: true}
+	if err := parseCLIFlags(newCLIFlags("cert-info", &o), &o, args); err != nil {
+		return nil, err
+	}
+	s, err := loadCLISession(&o)
+	if err != nil {
+		return nil, err
+	}
+
+	cert := s.info.Certificate
+	signer, err := NewSigner(s.info, nil)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	result := map[string]any{
+		"subject":         cert.Subject.String(),
+		"issuer":          cert.Issuer.String(),
+		"serial":          cert.SerialNumber.String(),
+		"not_before":      cert.NotBefore,
+		"not_after":       cert.NotAfter,
+		"expired":         now.After(cert.NotAfter),
+		"common_name":     s.info.CommonName,
+		"upn":             s.info.UPN,
+		"key_type":        signer.PublicKey().Type(),
+		"ssh_fingerprint": ssh.FingerprintSHA256(signer.PublicKey()),
+		"sha256":          CertFingerprint(cert),
+	}
+
+	upns, err := ParseUPNs(cert)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	result["upns"] = upns
+	if s.profile.UsernameRule != UsernameManual {
+		user, err := s.profile.SSHUser(s.info)
+		if err != nil {
+			return nil, err
+		}
+		result["ssh_user"] = user
+	}
+
+	usages, extUsages := KeyUsages(cert)
+	result["key_usage"] = usages
+	result["ext_key_usage"] = extUsages
+
+	chain := VerifyP12Chain(s.info)
+	var path []map[string]any
+	for _, c := range chain.Path {
+		path = append(path, map[string]any{
+			"subject":   c.Subject.String(),
+			"not_after": c.NotAfter,
+			"sha256":    CertFingerprint(c),
+		})
+	}
+	result["chain"] = map[string]any{
+		"path":        path,
+		"trusted":     chain.Trusted,
+		"client_auth": chain.UsageErr == nil,
+		"result":      chain.Result(),
+		"trust_store": chain.RootsPath,
+	}
+
+	if s.cfg.Revocation.Enabled {
+		status := CheckP12Revocation(s.cfg.Revocation, s.info)
+		result["revocation"] = map[string]any{
+			"state":  status.State,
+			"result": status.String(),
+		}
+	}
+
+	userCert, err := s.userCert()
+	if err != nil {
+		return nil, err
+	}
+	if userCert != nil {
+		result["ssh_certificate"] = map[string]any{
+			"key_id":         userCert.KeyId,
+			"serial":         userCert.Serial,
+			"principals":     userCert.ValidPrincipals,
+			"valid_after":    certTime(userCert.ValidAfter),
+			"valid_before":   certTime(userCert.ValidBefore),
+			"ca_fingerprint": ssh.FingerprintSHA256(userCert.SignatureKey),
+		}
+	}
+	return result, nil
+}
+
+func cliAgentAdd(args []string) (any, error) {
+	o := cliOptions{needP12: true}
+	fs := newCLIFlags("agent-add", &o)
+	lifetimeFlag := fs.String("lifetime", "", "remove the key from the agent after this long, e.g. 30m (default "+DefaultAgentLifetime.String()+")")
+	if err := parseCLIFlags(fs, &o, args); err != nil {
+		return nil, err
+	}
+	lifetime, err := ParseAgentLifetime(*lifetimeFlag)
+	if err != nil {
+		return nil, &usageError{err.Error()}
+	}
+	s, err := loadCLISession(&o)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := s.userCert()
+	if err != nil {
+		return nil, err
+	}
+
+	keyring, err := OpenAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer keyring.Close()
+	if err := AddToAgent(keyring, s.info, cert, lifetime); err != nil {
+		return nil, err
+	}
+
+	signer, err := NewSigner(s.info, nil)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Key added to ssh-agent for %s.", lifetime)
+	return map[string]any{
+		"fingerprint":     ssh.FingerprintSHA256(signer.PublicKey()),
+		"ssh_certificate": cert != nil,
+		"lifetime":        lifetime.String(),
+		"expires":         time.Now().Add(lifetime).Round(time.Second),
+	}, nil
+}
+
+func cliProfiles(args []string) (any, error) {
+	var o cliOptions
+	if err := parseCLIFlags(newCLIFlags("profiles", &o), &o, args); err != nil {
+		return nil, err
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	type profileInfo struct {
+		*Profile
+		Default bool `json:"default"`
+	}
+	list := make([]profileInfo, 0, len(cfg.Profiles))
+	for _, p := range cfg.Profiles {
+		cp := *p
+		if cp.SOCKS.Password != "" {
+			cp.SOCKS.Password = "(redacted)"
+		}
+		list = append(list, profileInfo{&cp, p.Name == cfg.DefaultProfile})
+	}
+	return list, nil
+}
+
+func cliImportRDP(args []string) (any, error) {
+	fs := flag.NewFlagSet("import-rdp", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	name := fs.String("name", "", "profile name (default: the file name); only with a single file")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, &usageError{err.Error()}
+	}
+	if fs.NArg() == 0 {
+		return nil, &usageError{"no .rdp file given"}
+	}
+	if *name != "" && fs.NArg() > 1 {
+		return nil, &usageError{"--name can only be used with a single file"}
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	type importResult struct {
+		File      string            `json:"file"`
+		Profile   string            `json:"profile"`
+		RDPTarget string            `json:"rdp_target"`
+		Mapped    []string          `json:"mapped"`
+		Kept      []string          `json:"kept"`
+		Dropped   map[string]string `json:"dropped"`
+	}
+	var results []importResult
+	for _, file := range fs.Args() {
+		imp, err := ImportRDPFile(cfg, file, *name)
+		if err == nil {
+			err = cfg.ImportProfile(imp.Profile)
+		}
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", file, err)
+		}
+		for _, line := range imp.Summary() {
+			log.Print(line)
+		}
+		results = append(results, importResult{file, imp.Profile.Name, imp.Profile.RDPTarget, imp.Mapped, imp.Kept, imp.Dropped})
+
+		// Save after each file so earlier imports survive a later failure
+		if err := SaveConfig(cfg); err != nil {
+			return results, fmt.Errorf("failed to save config: %w", err)
+		}
+	}
+	return results, nil
+}
+
+func cliImportSSHConfig(args []string) (any, error) {
+	fs := flag.NewFlagSet("import-ssh-config", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	file := fs.String("file", "", "ssh_config file to read (default ~/.ssh/config)")
+	live := fs.Bool("live", false, "keep hosts as aliases resolved from the file on each connect")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, err
+		}
+		return nil, &usageError{err.Error()}
+	}
+
+	path := *file
+	if path == "" {
+		var err error
+		if path, err = DefaultSSHConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	sc, err := LoadSSHConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	aliases := fs.Args()
+	if len(aliases) == 0 {
+		aliases = sc.Hosts()
+	}
+	if len(aliases) == 0 {
+		return nil, fmt.Errorf("no Host entries found in %s", path)
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	type importResult struct {
+		Alias      string     `json:"alias"`
+		Profile    string     `json:"profile"`
+		RemoteHost string     `json:"remote_host"`
+		RemoteUser string     `json:"remote_user,omitempty"`
+		JumpHosts  []JumpHost `json:"jump_hosts,omitempty"`
+		Notes      []string   `json:"notes,omitempty"`
+	}
+	result := struct {
+		File        string         `json:"file"`
+		Profiles    []importResult `json:"profiles"`
+		Unsupported []string       `json:"unsupported,omitempty"`
+	}{File: path, Unsupported: sc.Unsupported}
+
+	for _, alias := range aliases {
+		p, notes, err := sc.ProfileFromSSHConfig(alias, cfg.UniqueProfileName(alias), *live)
+		if err == nil {
+			err = cfg.ImportProfile(p)
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", alias, err)
+		}
+		log.Printf("Imported profile %q from %s: %s", p.Name, path, p.RemoteHost)
+		result.Profiles = append(result.Profiles, importResult{alias, p.Name, p.RemoteHost, p.RemoteUser, p.JumpHosts, notes})
+	}
+	for _, u := range sc.Unsupported {
+		log.Printf("Unsupported directive %s", u)
+	}
+
+	if err := SaveConfig(cfg); err != nil {
+		return result, fmt.Errorf("failed to save config: %w", err)
+	}
+	return result, nil
+}
+
+func cliHelp(args []string) (any, error) {
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", strings.ToLower(AppName))
+	for _, c := range cliCommands {
+		fmt.Fprintf(os.Stderr, "  %-18s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\nWithout a command the GUI starts.\n", strings.ToLower(AppName))
+	return nil, flag.ErrHelp
+}
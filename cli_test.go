@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCLIProfilesRedactsSecrets(t *testing.T) {
+	testConfigDir(t)
+	cfg := newConfig()
+	p, err := cfg.AddProfile("lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SOCKS = SOCKSSettings{Enabled: true, Username: "me", Password: "hunter2"}
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := cliProfiles(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "hunter2") {
+		t.Errorf("profiles output contains the SOCKS password: %s", data)
+	}
+
+	loaded, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Profile("lab").SOCKS.Password; got != "hunter2" {
+		t.Errorf("saved password = %q after listing profiles, want it unchanged", got)
+	}
+}
@@ -8,11 +8,34 @@ import (
 )
 
 type Config struct {
-	RemoteHost            string `json:"remote_host"`
-	RemoteUser            string `json:"remote_user"`
-	LocalPort             string `json:"local_port"`
-	P12Path               string `json:"p12_path"`
-	MinimizeToTrayWarning bool   `json:"minimize_to_tray_warning"`
+	Profiles              []*Profile `json:"profiles"`
+	DefaultProfile        string     `json:"default_profile"`
+	UserCAKeyPath         string     `json:"user_ca_key_path,omitempty"`
+	UserCAValidity        string     `json:"user_ca_validity,omitempty"`
+	MinimizeToTrayWarning bool       `json:"minimize_to_tray_warning"`
+	Proxy                 string     `json:"proxy,omitempty"`               // outbound proxy for the first SSH hop (see ParseProxy)
+	ExpiryWarningDays     []int      `json:"expiry_warning_days,omitempty"` // see ExpiryThresholds
+
+	// Revocation checks P12 certificates against their CRLs and OCSP responders before connecting
+	Revocation RevocationSettings `json:"revocation"`
+
+	// Single-connection settings from before profiles existed; LoadConfig moves them
+	// into a "Default" profile and they are dropped on the next save
+	RemoteHost   string     `json:"remote_host,omitempty"`
+	RemoteUser   string     `json:"remote_user,omitempty"`
+	LocalPort    string     `json:"local_port,omitempty"`
+	RDPTarget    string     `json:"rdp_target,omitempty"`
+	JumpHosts    []JumpHost `json:"jump_hosts,omitempty"`
+	P12Path      string     `json:"p12_path,omitempty"`
+	UserCertPath string     `json:"user_cert_path,omitempty"`
+}
+
+func newConfig() *Config {
+	return &Config{
+		Profiles:              []*Profile{newProfile(DefaultProfileName)},
+		DefaultProfile:        DefaultProfileName,
+		MinimizeToTrayWarning: true,
+	}
 }
 
 func GetConfigPath() (string, error) {
@@ -30,27 +53,62 @@ func GetConfigPath() (string, error) {
 func LoadConfig() (*Config, error) {
 	path, err := GetConfigPath()
 	if err != nil {
-		return &Config{LocalPort: "33890", MinimizeToTrayWarning: true}, err
+		return newConfig(), err
 	}
 
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return &Config{LocalPort: "33890", MinimizeToTrayWarning: true}, nil
+		return newConfig(), nil
 	}
 
 	var cfg Config
 	cfg.MinimizeToTrayWarning = true
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return &Config{LocalPort: "33890", MinimizeToTrayWarning: true}, nil
+		return newConfig(), nil
 	}
 
-	if cfg.LocalPort == "" {
-		cfg.LocalPort = "33890"
+	cfg.migrateLegacy()
+
+	if len(cfg.Profiles) == 0 {
+		cfg.Profiles = []*Profile{newProfile(DefaultProfileName)}
+	}
+	for _, p := range cfg.Profiles {
+		if p.LocalPort == "" {
+			p.LocalPort = DefaultLocalPort
+		}
+	}
+	if cfg.Profile(cfg.DefaultProfile) == nil {
+		cfg.DefaultProfile = cfg.Profiles[0].Name
 	}
 
 	return &cfg, nil
 }
 
+// migrateLegacy turns a pre-profile config.json into a single "Default" profile. Any
+// legacy field set is enough, so a file holding only a local port keeps it.
+func (c *Config) migrateLegacy() {
+	legacy := c.RemoteHost != "" || c.RemoteUser != "" || c.LocalPort != "" || c.RDPTarget != "" ||
+		len(c.JumpHosts) > 0 || c.P12Path != "" || c.UserCertPath != ""
+	if len(c.Profiles) > 0 || !legacy {
+		return
+	}
+
+	c.Profiles = []*Profile{{
+		Name:         DefaultProfileName,
+		RemoteHost:   c.RemoteHost,
+		RemoteUser:   c.RemoteUser,
+		LocalPort:    c.LocalPort,
+		RDPTarget:    c.RDPTarget,
+		JumpHosts:    c.JumpHosts,
+		P12Path:      c.P12Path,
+		UserCertPath: c.UserCertPath,
+	}}
+	c.DefaultProfile = DefaultProfileName
+
+	c.RemoteHost, c.RemoteUser, c.LocalPort, c.RDPTarget = "", "", "", ""
+	c.JumpHosts, c.P12Path, c.UserCertPath = nil, "", ""
+}
+
 func SaveConfig(cfg *Config) error {
 	path, err := GetConfigPath()
 	if err != nil {
@@ -62,5 +120,9 @@ func SaveConfig(cfg *Config) error {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0644)
+	// The file holds proxy and SOCKS passwords; WriteFile keeps the mode of an existing file
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600)
 }
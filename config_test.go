@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"testing"
+)
+
+// config.json holds proxy and SOCKS passwords, so it must not be readable by others,
+// including when an earlier version created it world-readable
+func TestSaveConfigMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not enforced on Windows")
+	}
+	testConfigDir(t)
+	path, err := GetConfigPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveConfig(newConfig()); err != nil {
+		t.Fatal(err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0600 {
+		t.Errorf("config.json mode = %v, want 0600", st.Mode().Perm())
+	}
+}
+
+// A pre-profile config.json is migrated whatever legacy field it holds
+func TestMigrateLegacy(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want func(p *Profile) bool
+	}{
+		{"host", `{"remote_host": "ssh.example.com", "local_port": "13390"}`, func(p *Profile) bool { return p.RemoteHost == "ssh.example.com" && p.LocalPort == "13390" }},
+		{"local port only", `{"local_port": "13390"}`, func(p *Profile) bool { return p.LocalPort == "13390" }},
+		{"rdp target only", `{"rdp_target": "desktop01:3389"}`, func(p *Profile) bool { return p.RDPTarget == "desktop01:3389" }},
+		{"user cert only", `{"user_cert_path": "/certs/id-cert.pub"}`, func(p *Profile) bool { return p.UserCertPath == "/certs/id-cert.pub" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfigDir(t)
+			path, err := GetConfigPath()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(tt.json), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := cfg.Profile(DefaultProfileName)
+			if len(cfg.Profiles) != 1 || p == nil || !tt.want(p) {
+				t.Fatalf("profiles after migrating %s = %+v", tt.json, cfg.Profiles)
+			}
+			if cfg.LocalPort != "" || cfg.RemoteHost != "" || cfg.RDPTarget != "" || cfg.UserCertPath != "" {
+				t.Error("legacy fields were not cleared")
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultExpiryWarningDays are the days before a certificate expires at which to warn
+var DefaultExpiryWarningDays = []int{30, 7, 1}
+
+// ExpiryCheckInterval is how often the GUI rechecks every profile's certificate
+const ExpiryCheckInterval = 24 * time.Hour
+
+// CertExpiry is what a profile remembers of its P12 certificate from the last time it
+// was loaded, since the file cannot be read again without its password
+type CertExpiry struct {
+	P12Path  string    `json:"p12_path"`
+	ModTime  time.Time `json:"mod_time"` // of the P12 file; a newer file makes the record stale
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"not_after"`
+
+	// Notified is the warning level already announced (see expiryLevel), so each
+	// threshold is only notified once
+	Notified int `json:"notified,omitempty"`
+}
+
+// CheckCertValidity refuses a certificate outside its validity period
+func CheckCertValidity(cert *x509.Certificate, now time.Time) error {
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("certificate %q expired on %s; renew it and select the new P12 file",
+			certName(cert), cert.NotAfter.Local().Format(time.DateOnly))
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("certificate %q is not valid until %s",
+			certName(cert), cert.NotBefore.Local().Format(time.DateTime))
+	}
+	return nil
+}
+
+// ParseExpiryWarningDays parses a comma-separated list of days such as "30,7,1";
+// empty means DefaultExpiryWarningDays
+func ParseExpiryWarningDays(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var days []int
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(f))
+		if err != nil || n < 1 || n > 365 {
+			return nil, fmt.Errorf("invalid warning threshold %q: days must be between 1 and 365", strings.TrimSpace(f))
+		}
+		days = append(days, n)
+	}
+	return days, nil
+}
+
+// FormatExpiryWarningDays is the inverse of ParseExpiryWarningDays
+func FormatExpiryWarningDays(days []int) string {
+	parts := make([]string, len(days))
+	for i, d := range days {
+		parts[i] = strconv.Itoa(d)
+	}
+	return strings.Join(parts, ",")
+}
+
+// ExpiryThresholds returns the configured warning days, largest first
+func (c *Config) ExpiryThresholds() []int {
+	days := c.ExpiryWarningDays
+	if len(days) == 0 {
+		days = DefaultExpiryWarningDays
+	}
+	days = append([]int(nil), days...)
+	sort.Sort(sort.Reverse(sort.IntSlice(days)))
+	return days
+}
+
+// expiryLevel is 0 outside every threshold, rising by one for each threshold crossed,
+// and len(thresholds)+1 once expired
+func expiryLevel(notAfter, now time.Time, thresholds []int) int {
+	left := notAfter.Sub(now)
+	if left <= 0 {
+		return len(thresholds) + 1
+	}
+	level := 0
+	for _, d := range thresholds {
+		if left <= time.Duration(d)*24*time.Hour {
+			level++
+		}
+	}
+	return level
+}
+
+// ExpiryNotice says how soon cert expires once it is within a warning threshold, or
+// is empty while it is not
+func (c *Config) ExpiryNotice(cert *x509.Certificate, now time.Time) string {
+	left := cert.NotAfter.Sub(now)
+	switch {
+	case left <= 0:
+		return "certificate expired on " + cert.NotAfter.Local().Format(time.DateOnly)
+	case expiryLevel(cert.NotAfter, now, c.ExpiryThresholds()) > 0:
+		return "certificate expires " + expiresIn(left)
+	}
+	return ""
+}
+
+// RecordCertExpiry remembers info's validity for the daily check, keeping the
+// notification state while the certificate is unchanged. It reports whether the
+// record changed and the config needs saving.
+func (p *Profile) RecordCertExpiry(info *P12Info) bool {
+	rec := &CertExpiry{
+		P12Path:  p.P12Path,
+		Subject:  info.Certificate.Subject.String(),
+		NotAfter: info.Certificate.NotAfter,
+	}
+	if st, err := os.Stat(p.P12Path); err == nil {
+		rec.ModTime = st.ModTime()
+	}
+
+	if old := p.CertExpiry; old != nil {
+		if old.P12Path == rec.P12Path && old.ModTime.Equal(rec.ModTime) &&
+			old.Subject == rec.Subject && old.NotAfter.Equal(rec.NotAfter) {
+			return false
+		}
+		if old.NotAfter.Equal(rec.NotAfter) && old.Subject == rec.Subject {
+			rec.Notified = old.Notified
+		}
+	}
+	p.CertExpiry = rec
+	return true
+}
+
+// ExpiryWarning is a profile whose certificate is within a warning threshold or expired
+type ExpiryWarning struct {
+	Profile  string
+	Subject  string
+	NotAfter time.Time
+	Left     time.Duration // until NotAfter when checked; negative once expired
+	Expired  bool
+	Level    int  // see expiryLevel
+	New      bool // Level has not been notified yet
+}
+
+// Message describes the warning for the status bar and notifications
+func (w ExpiryWarning) Message() string {
+	date := w.NotAfter.Local().Format(time.DateOnly)
+	if w.Expired {
+		return fmt.Sprintf("Certificate for %s expired on %s", w.Profile, date)
+	}
+	return fmt.Sprintf("Certificate for %s expires %s (%s)", w.Profile, expiresIn(w.Left), date)
+}
+
+func expiresIn(left time.Duration) string {
+	switch days := int(left / (24 * time.Hour)); {
+	case days == 0:
+		return "within a day"
+	case days == 1:
+		return "in 1 day"
+	default:
+		return fmt.Sprintf("in %d days", days)
+	}
+}
+
+// CheckExpiry returns the profiles whose recorded certificate is within a warning
+// threshold or expired, most urgent first. Records for a P12 file that has since been
+// changed or replaced are skipped until the file is loaded again.
+func (c *Config) CheckExpiry(now time.Time) []ExpiryWarning {
+	thresholds := c.ExpiryThresholds()
+
+	var warnings []ExpiryWarning
+	for _, p := range c.Profiles {
+		rec := p.CertExpiry
+		if rec == nil || p.UseAgent || rec.P12Path != p.P12Path {
+			continue
+		}
+		if st, err := os.Stat(p.P12Path); err != nil || !st.ModTime().Equal(rec.ModTime) {
+			continue
+		}
+
+		level := expiryLevel(rec.NotAfter, now, thresholds)
+		if level == 0 {
+			continue
+		}
+		warnings = append(warnings, ExpiryWarning{
+			Profile:  p.Name,
+			Subject:  rec.Subject,
+			NotAfter: rec.NotAfter,
+			Left:     rec.NotAfter.Sub(now),
+			Expired:  !now.Before(rec.NotAfter),
+			Level:    level,
+			New:      level > rec.Notified,
+		})
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].NotAfter.Before(warnings[j].NotAfter)
+	})
+	return warnings
+}
+
+// MarkExpiryNotified records that the warnings have been announced
+func (c *Config) MarkExpiryNotified(warnings []ExpiryWarning) {
+	for _, w := range warnings {
+		if p := c.Profile(w.Profile); p != nil && p.CertExpiry != nil && w.Level > p.CertExpiry.Notified {
+			p.CertExpiry.Notified = w.Level
+		}
+	}
+}
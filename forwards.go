@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Forward kinds (Forward.Kind), as in ssh -L, -R and -D
+const (
+	ForwardLocal   = "L" // local port to a host:port reached from the SSH server
+	ForwardRemote  = "R" // port on the SSH server to a host:port reached from this computer
+	ForwardDynamic = "D" // local SOCKS5 proxy; the SSH server connects to each requested address
+)
+
+// Forward is an additional port forward carried by a tunnel's SSH connection
+type Forward struct {
+	Kind        string `json:"kind"`
+	BindAddress string `json:"bind_address,omitempty"` // default: loopback
+	Port        string `json:"port"`
+	Target      string `json:"target,omitempty"` // host:port; unused for dynamic forwards
+}
+
+// ListenAddress is where the forward accepts connections: locally for L and D, on the SSH server for R
+func (f Forward) ListenAddress() string {
+	bind := f.BindAddress
+	switch {
+	case bind != "":
+	case f.Kind == ForwardRemote:
+		// Listen resolves the address locally, so name the server's loopback explicitly
+		bind = "127.0.0.1"
+	default:
+		bind = "localhost"
+	}
+	return net.JoinHostPort(bind, f.Port)
+}
+
+// String formats the forward in the syntax ParseForward accepts, e.g. "L 5985:winrm01:5985"
+func (f Forward) String() string {
+	spec := f.Port
+	if f.BindAddress != "" {
+		spec = bracketHost(f.BindAddress) + ":" + spec
+	}
+	if f.Kind != ForwardDynamic {
+		host, port, _ := net.SplitHostPort(f.Target)
+		spec += ":" + bracketHost(host) + ":" + port
+	}
+	return f.Kind + " " + spec
+}
+
+func bracketHost(host string) string {
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+	return host
+}
+
+// ParseForward parses "L [bind:]port:host:hostport", "R [bind:]port:host:hostport" or
+// "D [bind:]port". A leading dash (-L) is accepted; IPv6 addresses go in brackets.
+func ParseForward(spec string) (Forward, error) {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(spec), " ")
+	f := Forward{Kind: strings.ToUpper(strings.TrimPrefix(kind, "-"))}
+	parts, err := splitForwardSpec(strings.TrimSpace(rest))
+	if err != nil {
+		return f, fmt.Errorf("forward %q: %w", spec, err)
+	}
+
+	switch {
+	case f.Kind == ForwardDynamic && len(parts) == 1:
+		f.Port = parts[0]
+	case f.Kind == ForwardDynamic && len(parts) == 2:
+		f.BindAddress, f.Port = parts[0], parts[1]
+	case (f.Kind == ForwardLocal || f.Kind == ForwardRemote) && len(parts) == 3:
+		f.Port, f.Target = parts[0], net.JoinHostPort(parts[1], parts[2])
+	case (f.Kind == ForwardLocal || f.Kind == ForwardRemote) && len(parts) == 4:
+		f.BindAddress, f.Port, f.Target = parts[0], parts[1], net.JoinHostPort(parts[2], parts[3])
+	case f.Kind == ForwardDynamic:
+		return f, fmt.Errorf("forward %q: expected D [bind:]port", spec)
+	case f.Kind == ForwardLocal || f.Kind == ForwardRemote:
+		return f, fmt.Errorf("forward %q: expected %s [bind:]port:host:hostport", spec, f.Kind)
+	default:
+		return f, fmt.Errorf("forward %q: type must be L, R or D", spec)
+	}
+
+	if n, err := strconv.Atoi(f.Port); err != nil || n < 1 || n > 65535 {
+		return f, fmt.Errorf("forward %q: invalid port %q", spec, f.Port)
+	}
+	if f.Target != "" {
+		host, port, _ := net.SplitHostPort(f.Target)
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 || host == "" {
+			return f, fmt.Errorf("forward %q: invalid target %q", spec, f.Target)
+		}
+	}
+	return f, nil
+}
+
+// splitForwardSpec splits on colons outside [brackets] and strips the brackets
+func splitForwardSpec(s string) ([]string, error) {
+	var parts []string
+	var cur strings.Builder
+	inBracket := false
+	for _, r := range s {
+		switch {
+		case r == '[' && !inBracket:
+			inBracket = true
+		case r == ']' && inBracket:
+			inBracket = false
+		case r == ':' && !inBracket:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	if inBracket {
+		return nil, fmt.Errorf("unterminated [")
+	}
+	return append(parts, cur.String()), nil
+}
+
+// ParseForwards parses one forward per line. Blank lines and lines starting with # are ignored.
+func ParseForwards(text string) ([]Forward, error) {
+	var forwards []Forward
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f, err := ParseForward(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards, nil
+}
+
+// FormatForwards is the inverse of ParseForwards
+func FormatForwards(forwards []Forward) string {
+	var lines []string
+	for _, f := range forwards {
+		lines = append(lines, f.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// mergeForwards appends the forwards of extra whose listen address is not used in base
+func mergeForwards(base, extra []Forward) []Forward {
+	merged := append([]Forward(nil), base...)
+	for _, f := range extra {
+		taken := false
+		for _, b := range merged {
+			if b.Kind == f.Kind && b.ListenAddress() == f.ListenAddress() {
+				taken = true
+				break
+			}
+		}
+		if !taken {
+			merged = append(merged, f)
+		}
+	}
+	return merged
+}
+
+// ForwardCounter tracks the connections and traffic of one forward for the session view
+type ForwardCounter struct {
+	Forward Forward
+	Label   string // shown instead of the forward spec, e.g. "RDP"
+
+	active   atomic.Int64
+	total    atomic.Int64
+	sent     atomic.Int64 // bytes towards the target
+	received atomic.Int64 // bytes from the target
+
+	mu    sync.Mutex
+	state string // empty while working; otherwise why the forward is unavailable
+}
+
+func newForwardCounter(f Forward, label string) *ForwardCounter {
+	if label == "" {
+		label = f.String()
+	}
+	return &ForwardCounter{Forward: f, Label: label}
+}
+
+func (c *ForwardCounter) setState(state string) {
+	c.mu.Lock()
+	c.state = state
+	c.mu.Unlock()
+}
+
+// Bytes returns the bytes sent to and received from the target so far
+func (c *ForwardCounter) Bytes() (sent, received int64) {
+	return c.sent.Load(), c.received.Load()
+}
+
+// Summary describes the forward's state for the session view
+func (c *ForwardCounter) Summary() string {
+	c.mu.Lock()
+	state := c.state
+	c.mu.Unlock()
+	if state != "" {
+		return fmt.Sprintf("%s: %s", c.Label, state)
+	}
+	sent, received := c.Bytes()
+	return fmt.Sprintf("%s: %d open, %d total, %s sent, %s received",
+		c.Label, c.active.Load(), c.total.Load(), formatBytes(sent), formatBytes(received))
+}
+
+// formatBytes formats a byte count with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// countingWriter adds the bytes written to n as they pass, so counters move during a transfer
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// relay copies between the accepted connection and the one opened to the target until
+// either side closes, and returns the bytes sent to and received from the target
+func relay(accepted, target net.Conn, counter *ForwardCounter) (sent, received int64) {
+	counter.active.Add(1)
+	counter.total.Add(1)
+	defer counter.active.Add(-1)
+
+	done := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(countingWriter{accepted, &counter.received}, target)
+		accepted.Close()
+		done <- n
+	}()
+	sent, _ = io.Copy(countingWriter{target, &counter.sent}, accepted)
+	target.Close()
+	return sent, <-done
+}
+
+// startForwards opens the listeners of the extra forwards on client. They close when ctx
+// ends. Local listeners that cannot be opened fail the tunnel; remote forwards the server
+// refuses are reported on their counter and retried after a reconnect.
+func startForwards(ctx context.Context, client *supervisedClient, forwards []Forward, logFunc func(string)) ([]*ForwardCounter, error) {
+	var counters []*ForwardCounter
+	for _, f := range forwards {
+		counter := newForwardCounter(f, "")
+		if f.Kind == ForwardRemote {
+			go serveRemoteForward(ctx, client, counter, logFunc)
+			counters = append(counters, counter)
+			continue
+		}
+
+		listener, err := net.Listen("tcp", f.ListenAddress())
+		if err != nil {
+			return nil, fmt.Errorf("failed to start forward %s: %w", f, err)
+		}
+		logFunc(fmt.Sprintf("Forward %s listening on %s", f, listener.Addr()))
+		serveListener(ctx, listener, func(conn net.Conn) {
+			serveForwardConn(ctx, client, conn, counter, logFunc)
+		})
+		counters = append(counters, counter)
+	}
+	return counters, nil
+}
+
+// serveListener handles each connection accepted by listener in its own goroutine,
+// closing the listener when ctx ends
+func serveListener(ctx context.Context, listener net.Listener, handle func(conn net.Conn)) {
+	context.AfterFunc(ctx, func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+}
+
+// serveForwardConn connects an accepted local connection through the SSH server
+func serveForwardConn(ctx context.Context, client *supervisedClient, conn net.Conn, counter *ForwardCounter, logFunc func(string)) {
+	f := counter.Forward
+	target := f.Target
+	var remote net.Conn
+	var err error
+	if f.Kind == ForwardDynamic {
+		// Dynamic forwards behave like ssh -D: no authentication and no rules
+		target, remote, err = (&SOCKSServer{}).Handshake(ctx, conn, client.Dial)
+	} else {
+		remote, err = client.Dial(ctx, "tcp", target)
+	}
+	if err != nil {
+		conn.Close()
+		logFunc(fmt.Sprintf("Forward %s: connection from %s to %s failed: %v", f, conn.RemoteAddr(), target, err))
+		return
+	}
+
+	sent, received := relay(conn, remote, counter)
+	logFunc(fmt.Sprintf("Forward %s: connection from %s to %s closed. Sent %d, received %d bytes", f, conn.RemoteAddr(), target, sent, received))
+}
+
+// serveRemoteForward asks the SSH server to listen for the forward and connects each
+// connection it accepts to the target from this computer. The server-side listener ends
+// with the SSH connection, so it is requested again after every reconnect.
+func serveRemoteForward(ctx context.Context, client *supervisedClient, counter *ForwardCounter, logFunc func(string)) {
+	f := counter.Forward
+	for ctx.Err() == nil {
+		listener, replaced, err := client.Listen(ctx, f.ListenAddress())
+		if err != nil {
+			if ctx.Err() == nil {
+				counter.setState("failed: " + err.Error())
+				logFunc(fmt.Sprintf("Forward %s: server refused to listen on %s: %v", f, f.ListenAddress(), err))
+			}
+		} else {
+			counter.setState("")
+			logFunc(fmt.Sprintf("Forward %s listening on the server at %s", f, f.ListenAddress()))
+			stop := context.AfterFunc(ctx, func() { listener.Close() })
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					break
+				}
+				go func() {
+					local, err := (&net.Dialer{Timeout: 10 * time.Second}).DialContext(ctx, "tcp", f.Target)
+					if err != nil {
+						conn.Close()
+						logFunc(fmt.Sprintf("Forward %s: connection to %s failed: %v", f, f.Target, err))
+						return
+					}
+					sent, received := relay(conn, local, counter)
+					logFunc(fmt.Sprintf("Forward %s: connection to %s closed. Sent %d, received %d bytes", f, f.Target, sent, received))
+				}()
+			}
+			stop()
+			listener.Close()
+		}
+
+		// Wait for the client to be replaced (or for the tunnel to close) before asking again
+		select {
+		case <-replaced:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
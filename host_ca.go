@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// hostAuthority is a single @cert-authority (or @revoked) known_hosts entry
+type hostAuthority struct {
+	patterns []string
+	key      ssh.PublicKey
+}
+
+// hostAuthorities holds the CA and revocation markers from a known_hosts file
+type hostAuthorities struct {
+	authorities []hostAuthority
+	revoked     []ssh.PublicKey
+}
+
+// loadHostAuthorities reads the @cert-authority and @revoked entries of a known_hosts file
+func loadHostAuthorities(filename string) (*hostAuthorities, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+
+	result := &hostAuthorities{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// Blank, comment and malformed lines are skipped here; knownhosts.New
+		// reads the same file and reports syntax errors
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			continue
+		}
+
+		switch marker {
+		case "cert-authority":
+			result.authorities = append(result.authorities, hostAuthority{patterns: hosts, key: key})
+		case "revoked":
+			result.revoked = append(result.revoked, key)
+		}
+	}
+	return result, nil
+}
+
+// hasAuthorityFor reports whether any CA is trusted for address ("host:port")
+func (h *hostAuthorities) hasAuthorityFor(address string) bool {
+	for _, a := range h.authorities {
+		if matchHostPatterns(a.patterns, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuthority implements ssh.CertChecker.IsHostAuthority
+func (h *hostAuthorities) isAuthority(auth ssh.PublicKey, address string) bool {
+	for _, a := range h.authorities {
+		if keysEqual(a.key, auth) && matchHostPatterns(a.patterns, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRevoked implements ssh.CertChecker.IsRevoked; both the certificate's own key
+// and its signing CA can be revoked
+func (h *hostAuthorities) isRevoked(cert *ssh.Certificate) bool {
+	for _, k := range h.revoked {
+		if keysEqual(k, cert.Key) || keysEqual(k, cert.SignatureKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func keysEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// matchHostPatterns applies OpenSSH known_hosts matching to address ("host:port"):
+// wildcards (* and ?), negation (!pattern), [host]:port forms and hashed |1| entries.
+// Any matching negated pattern rejects the host outright. As in OpenSSH, a hashed
+// entry is the whole host field, never one pattern of a list.
+func matchHostPatterns(patterns []string, address string) bool {
+	candidate := knownhosts.Normalize(address)
+	if len(patterns) == 1 && strings.HasPrefix(patterns[0], "|1|") {
+		return matchHashedHost(patterns[0], candidate)
+	}
+
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		if negate {
+			p = p[1:]
+		}
+		if !matchHostPattern(p, candidate) {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+func matchHostPattern(pattern, candidate string) bool {
+	// OpenSSH host patterns are case-insensitive; path.Match gives us * and ?
+	// ('/' never appears in host names, and '[' only in the [host]:port form)
+	pattern = strings.ToLower(pattern)
+	candidate = strings.ToLower(candidate)
+	if !strings.ContainsAny(pattern, "*?") {
+		return pattern == candidate
+	}
+	ok, err := path.Match(escapeBrackets(pattern), candidate)
+	return err == nil && ok
+}
+
+// escapeBrackets keeps the literal brackets of [host]:port patterns away from path.Match
+func escapeBrackets(pattern string) string {
+	return strings.NewReplacer("[", `\[`, "]", `\]`).Replace(pattern)
+}
+
+// matchHashedHost checks a |1|salt|hash entry as written by ssh-keygen -H
+func matchHashedHost(entry, candidate string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(candidate))
+	return hmac.Equal(mac.Sum(nil), want)
+}
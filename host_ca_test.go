@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestSSHKey(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// sameAddr is a remote address that knownhosts skips as a second candidate, so only
+// the host name is matched
+type sameAddr string
+
+func (sameAddr) Network() string  { return "tcp" }
+func (a sameAddr) String() string { return string(a) }
+
+func TestMatchHostPatterns(t *testing.T) {
+	hashed := knownhosts.HashHostname("bastion.example.com")
+	hashedPort := knownhosts.HashHostname("[bastion.example.com]:2222")
+
+	tests := []struct {
+		patterns string
+		address  string
+		want     bool
+		differs  string // why knownhosts.New cannot serve as the reference
+	}{
+		{"bastion.example.com", "bastion.example.com:22", true, ""},
+		{"BASTION.example.com", "bastion.example.com:22", true, "OpenSSH ignores case, knownhosts does not"},
+		{"bastion.example.com", "other.example.com:22", false, ""},
+		{"bastion.example.com", "bastion.example.com:2222", false, ""},
+		{"*.example.com", "bastion.example.com:22", true, ""},
+		{"*.example.com", "example.com:22", false, ""},
+		{"bastion?.example.com", "bastion1.example.com:22", true, ""},
+		{"bastion?.example.com", "bastion10.example.com:22", false, ""},
+		{"[bastion.example.com]:2222", "bastion.example.com:2222", true, ""},
+		{"[bastion.example.com]:2222", "bastion.example.com:22", false, ""},
+		{"[*.example.com]:2222", "bastion.example.com:2222", true, ""},
+		{"*.example.com", "bastion.example.com:2222", false, ""},
+		{"10.0.0.*", "10.0.0.7:22", true, ""},
+		{"[::1]:2222", "[::1]:2222", true, ""},
+		{"*.example.com,!bastion.example.com", "bastion.example.com:22", false, ""},
+		{"*.example.com,!bastion.example.com", "web.example.com:22", true, ""},
+		{"!bastion.example.com,*.example.com", "bastion.example.com:22", false, ""},
+		{"!bastion.example.com", "web.example.com:22", false, ""},
+		{"!*.internal,*", "db.internal:22", false, ""},
+		{hashed, "bastion.example.com:22", true, ""},
+		{hashed, "web.example.com:22", false, ""},
+		{hashed, "bastion.example.com:2222", false, ""},
+		{hashedPort, "bastion.example.com:2222", true, ""},
+		{"web.example.com," + hashed, "bastion.example.com:22", false, ""},
+		{"|1|bm90IGJhc2U2NA|x", "bastion.example.com:22", false, "knownhosts rejects the file"},
+	}
+
+	key := newTestSSHKey(t).PublicKey()
+	for _, tt := range tests {
+		patterns := strings.Split(tt.patterns, ",")
+		if got := matchHostPatterns(patterns, tt.address); got != tt.want {
+			t.Errorf("matchHostPatterns(%s, %s) = %v, want %v", tt.patterns, tt.address, got, tt.want)
+		}
+
+		// knownhosts must agree for a plain key entry with the same patterns
+		if tt.differs != "" {
+			continue
+		}
+		path := filepath.Join(t.TempDir(), "known_hosts")
+		line := tt.patterns + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + "\n"
+		if err := os.WriteFile(path, []byte(line), 0600); err != nil {
+			t.Fatal(err)
+		}
+		callback, err := knownhosts.New(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if known := callback(tt.address, sameAddr(tt.address), key) == nil; known != tt.want {
+			t.Errorf("knownhosts disagrees on %s for %s: %v", tt.patterns, tt.address, known)
+		}
+	}
+}
+
+func TestHostAuthorities(t *testing.T) {
+	ca, otherCA, revokedCA := newTestSSHKey(t), newTestSSHKey(t), newTestSSHKey(t)
+	hostKey, revokedHost := newTestSSHKey(t), newTestSSHKey(t)
+	authorized := func(s ssh.Signer) string { return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(s.PublicKey()))) }
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	data := strings.Join([]string{
+		"# comment",
+		"@cert-authority *.example.com,!*.lab.example.com " + authorized(ca),
+		"@cert-authority [gw.example.net]:2222 " + authorized(otherCA),
+		"@cert-authority revoked.example.com " + authorized(revokedCA),
+		"@revoked * " + authorized(revokedCA),
+		"@revoked * " + authorized(revokedHost),
+		"plain.example.org " + authorized(hostKey),
+		"not a known_hosts line",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := loadHostAuthorities(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		address string
+		ca      ssh.Signer
+		want    bool
+	}{
+		{"web.example.com:22", ca, true},
+		{"web.lab.example.com:22", ca, false},
+		{"web.example.com:22", otherCA, false},
+		{"gw.example.net:2222", otherCA, true},
+		{"gw.example.net:22", otherCA, false},
+		{"plain.example.org:22", ca, false},
+	} {
+		if got := h.isAuthority(tt.ca.PublicKey(), tt.address); got != tt.want {
+			t.Errorf("isAuthority(%s) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+
+	if !h.hasAuthorityFor("web.example.com:22") || h.hasAuthorityFor("web.lab.example.com:22") || h.hasAuthorityFor("plain.example.org:22") {
+		t.Error("hasAuthorityFor does not follow the @cert-authority patterns")
+	}
+
+	cert := func(key, signer ssh.Signer) *ssh.Certificate {
+		c := &ssh.Certificate{Key: key.PublicKey(), CertType: ssh.HostCert, ValidPrincipals: []string{"web.example.com"}, ValidBefore: ssh.CertTimeInfinity}
+		if err := c.SignCert(rand.Reader, signer); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if h.isRevoked(cert(hostKey, ca)) {
+		t.Error("certificate signed by a trusted CA reported revoked")
+	}
+	if !h.isRevoked(cert(hostKey, revokedCA)) {
+		t.Error("certificate signed by a revoked CA was accepted")
+	}
+	if !h.isRevoked(cert(revokedHost, ca)) {
+		t.Error("certificate for a revoked host key was accepted")
+	}
+}
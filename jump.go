@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// JumpHost is one bastion hop on the way to the target SSH server (ProxyJump)
+type JumpHost struct {
+	Host    string `json:"host"`
+	Port    string `json:"port,omitempty"`     // default 22
+	User    string `json:"user,omitempty"`     // default: the connection's SSH user
+	KeyPath string `json:"key_path,omitempty"` // OpenSSH private key; empty uses the P12 identity
+}
+
+// Address returns host:port for dialing the hop
+func (j JumpHost) Address() string {
+	port := j.Port
+	if port == "" {
+		port = "22"
+	}
+	return net.JoinHostPort(j.Host, port)
+}
+
+// String formats the hop in ProxyJump syntax: [user@]host[:port]
+func (j JumpHost) String() string {
+	s := j.Host
+	if j.Port != "" && j.Port != "22" {
+		s = net.JoinHostPort(j.Host, j.Port)
+	}
+	if j.User != "" {
+		s = j.User + "@" + s
+	}
+	return s
+}
+
+// ParseJumpHost parses "[user@]host[:port]"
+func ParseJumpHost(spec string) (JumpHost, error) {
+	var j JumpHost
+	spec = strings.TrimSpace(spec)
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		j.User, spec = spec[:at], spec[at+1:]
+	}
+
+	if host, port, err := net.SplitHostPort(spec); err == nil {
+		j.Host, j.Port = host, port
+	} else {
+		j.Host = strings.Trim(spec, "[]")
+	}
+	if j.Host == "" {
+		return j, fmt.Errorf("jump host %q has no host name", spec)
+	}
+	return j, nil
+}
+
+// ParseJumpHosts parses one hop per line: "[user@]host[:port] [private-key-path]".
+// Blank lines and lines starting with # are ignored.
+func ParseJumpHosts(text string) ([]JumpHost, error) {
+	var hops []JumpHost
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		spec, keyPath, _ := strings.Cut(line, " ")
+		hop, err := ParseJumpHost(spec)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		hop.KeyPath = strings.Trim(strings.TrimSpace(keyPath), `"`)
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// FormatJumpHosts is the inverse of ParseJumpHosts
+func FormatJumpHosts(hops []JumpHost) string {
+	var lines []string
+	for _, hop := range hops {
+		line := hop.String()
+		if hop.KeyPath != "" {
+			line += " " + hop.KeyPath
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// loadKeyFile reads an OpenSSH private key for a jump host, asking the prompter for
+// the passphrase of encrypted keys
+func loadKeyFile(path string, prompter Prompter) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) && prompter != nil {
+		pass, ok := prompter.Secret("Private Key Passphrase", "Passphrase for "+filepath.Base(path))
+		if !ok {
+			return nil, fmt.Errorf("passphrase entry cancelled for %s", path)
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, []byte(pass))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// dialSSH connects to opts.Host, hopping through each jump host in order. Closing the
+// returned client tears down the whole chain; ending ctx abandons a dial in progress.
+func dialSSH(ctx context.Context, opts ConnectOptions, logFunc func(string)) (*ssh.Client, error) {
+	// The agent connection lives as long as the client: it signs for every hop
+	// without a key of its own and serves forwarded agent requests
+	var keyring *SSHAgent
+	if opts.UseAgent || opts.ForwardAgent {
+		var err error
+		if keyring, err = OpenAgent(); err != nil {
+			if opts.UseAgent {
+				return nil, err
+			}
+			logFunc(fmt.Sprintf("Agent forwarding disabled: %v", err))
+		}
+	}
+	var auth agent.Agent
+	if keyring != nil && opts.UseAgent {
+		keys, err := keyring.List()
+		if err == nil && len(keys) == 0 {
+			err = errors.New("the ssh-agent holds no keys")
+		}
+		if err != nil {
+			keyring.Close()
+			return nil, err
+		}
+		logFunc(fmt.Sprintf("Authenticating with %d key(s) from ssh-agent.", len(keys)))
+		auth = keyring
+	}
+
+	type hop struct {
+		addr   string
+		user   string
+		signer ssh.Signer
+	}
+
+	var hops []hop
+	for _, j := range opts.JumpHosts {
+		h := hop{addr: j.Address(), user: j.User, signer: opts.Signer}
+		if h.user == "" {
+			h.user = opts.User
+		}
+		if j.KeyPath != "" {
+			signer, err := loadKeyFile(j.KeyPath, opts.Prompter)
+			if err != nil {
+				if keyring != nil {
+					keyring.Close()
+				}
+				return nil, err
+			}
+			h.signer = signer
+		}
+		hops = append(hops, h)
+	}
+	hops = append(hops, hop{addr: opts.Address(), user: opts.User, signer: opts.Signer})
+
+	var chain []*ssh.Client
+	closeChain := func() {
+		for i := len(chain) - 1; i >= 0; i-- {
+			chain[i].Close()
+		}
+		if keyring != nil {
+			keyring.Close()
+		}
+	}
+
+	for i, h := range hops {
+		if err := ctx.Err(); err != nil {
+			closeChain()
+			return nil, err
+		}
+		config, err := getSSHConfig(h.addr, h.user, h.signer, auth, opts.Prompter)
+		if err != nil {
+			closeChain()
+			return nil, err
+		}
+
+		label := fmt.Sprintf("hop %d/%d", i+1, len(hops))
+		if i == len(hops)-1 {
+			label = "target"
+		}
+
+		var client *ssh.Client
+		if i == 0 {
+			logFunc(fmt.Sprintf("Dialing SSH to %s as %s (%s)...", h.addr, h.user, label))
+			client, err = dialFirstHop(ctx, opts.Proxy, h.addr, config, logFunc)
+		} else {
+			logFunc(fmt.Sprintf("Dialing SSH to %s as %s via %s (%s)...", h.addr, h.user, hops[i-1].addr, label))
+			client, err = dialThrough(ctx, chain[i-1], h.addr, config)
+		}
+		if err != nil {
+			closeChain()
+			return nil, fmt.Errorf("%s (%s): %w", label, h.addr, err)
+		}
+
+		logFunc(fmt.Sprintf("SSH connection to %s established.", h.addr))
+		chain = append(chain, client)
+	}
+
+	target := chain[len(chain)-1]
+	if keyring != nil && opts.ForwardAgent {
+		if err := forwardAgent(target, keyring); err != nil {
+			logFunc(fmt.Sprintf("Agent forwarding failed: %v", err))
+		} else {
+			logFunc("Agent forwarding enabled; the server can use your agent keys until the tunnel closes.")
+		}
+	}
+	if len(chain) > 1 || keyring != nil {
+		go func() {
+			target.Wait()
+			closeChain()
+		}()
+	}
+	return target, nil
+}
+
+// dialFirstHop opens the SSH connection to the first hop, through the proxy when one is set
+func dialFirstHop(ctx context.Context, proxySetting, addr string, config *ssh.ClientConfig, logFunc func(string)) (*ssh.Client, error) {
+	var conn net.Conn
+	var err error
+	if proxySetting == "" {
+		conn, err = (&net.Dialer{Timeout: config.Timeout}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialProxy(ctx, proxySetting, addr, config.Timeout, logFunc)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newClientConn(ctx, conn, addr, config)
+}
+
+// dialThrough opens an SSH connection to addr tunnelled over an existing client
+func dialThrough(ctx context.Context, via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := via.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return newClientConn(ctx, conn, addr, config)
+}
+
+// newClientConn runs the SSH handshake over conn, closing it if ctx ends first
+func newClientConn(ctx context.Context, conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
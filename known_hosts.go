@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Prompter lets the SSH layer ask the user for decisions during a handshake.
+// Implementations are called from connection goroutines, never the UI thread.
+type Prompter interface {
+	// ConfirmHostKey is called for hosts that are not yet in known_hosts.
+	// Returning true records the key (trust on first use) and continues.
+	ConfirmHostKey(hostname string, remote net.Addr, key ssh.PublicKey) bool
+
+	// Secret asks for a hidden value such as a key passphrase; ok is false if cancelled.
+	Secret(title, message string) (value string, ok bool)
+
+	// Challenge asks the questions of a keyboard-interactive or password prompt,
+	// returning one answer per question. Answers whose echo flag is false must be
+	// entered hidden; ok is false if cancelled.
+	Challenge(title, message string, questions []string, echos []bool) (answers []string, ok bool)
+}
+
+// HostKeyChangedError is returned when a server presents a key that does not
+// match the one recorded in known_hosts.
+type HostKeyChangedError struct {
+	Host string
+	Key  ssh.PublicKey
+	Want []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	var known []string
+	for _, k := range e.Want {
+		known = append(known, fmt.Sprintf("%s %s (%s:%d)", k.Key.Type(), ssh.FingerprintSHA256(k.Key), k.Filename, k.Line))
+	}
+	return fmt.Sprintf("host key changed for %s: server presented %s %s, expected %s. "+
+		"This could be a man-in-the-middle attack; if the change is legitimate, remove the old entry from known_hosts",
+		e.Host, e.Key.Type(), ssh.FingerprintSHA256(e.Key), strings.Join(known, ", "))
+}
+
+// GetKnownHostsPath returns the app-managed known_hosts file, creating an empty one if needed
+func GetKnownHostsPath() (string, error) {
+	cfgPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(filepath.Dir(cfgPath), "known_hosts")
+
+	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return path, nil
+}
+
+// newHostKeyCallback verifies host keys against the app known_hosts file (OpenSSH format,
+// hashed entries supported). Host certificates are validated against @cert-authority
+// entries; plain keys fall back to trust on first use, and mismatches fail hard.
+func newHostKeyCallback(prompter Prompter) (ssh.HostKeyCallback, error) {
+	path, err := GetKnownHostsPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate known_hosts: %w", err)
+	}
+
+	fallback, err := newKnownKeyCallback(path, prompter)
+	if err != nil {
+		return nil, err
+	}
+
+	authorities, err := loadHostAuthorities(path)
+	if err != nil {
+		return nil, err
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: authorities.isAuthority,
+		IsRevoked:       authorities.isRevoked,
+		HostKeyFallback: fallback,
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if cert, ok := key.(*ssh.Certificate); ok && !authorities.hasAuthorityFor(hostname) {
+			// No CA is trusted for this host: treat the certificate's key like a plain host key
+			return fallback(hostname, remote, cert.Key)
+		}
+		return checker.CheckHostKey(hostname, remote, key)
+	}, nil
+}
+
+// newKnownKeyCallback checks plain host keys, prompting for unknown hosts
+func newKnownKeyCallback(path string, prompter Prompter) (ssh.HostKeyCallback, error) {
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			// nil on match, or a revocation error
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return &HostKeyChangedError{Host: hostname, Key: key, Want: keyErr.Want}
+		}
+
+		if prompter == nil || !prompter.ConfirmHostKey(hostname, remote, key) {
+			return fmt.Errorf("host key for %s (%s) was not trusted", hostname, ssh.FingerprintSHA256(key))
+		}
+		return appendKnownHost(path, hostname, key)
+	}, nil
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms matching keys already recorded
+// for addr, so the server is asked for a key we can verify rather than a different type.
+func knownHostKeyAlgorithms(addr string) []string {
+	path, err := GetKnownHostsPath()
+	if err != nil {
+		return nil
+	}
+
+	// Leave the defaults (certificates first) when a host CA covers this host
+	if authorities, err := loadHostAuthorities(path); err == nil && authorities.hasAuthorityFor(addr) {
+		return nil
+	}
+
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil
+	}
+
+	// Checking a placeholder key yields a KeyError listing every known key for the host
+	var keyErr *knownhosts.KeyError
+	if err := check(addr, &net.TCPAddr{}, placeholderKey{}); !errors.As(err, &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	for _, k := range keyErr.Want {
+		if k.Key.Type() == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, k.Key.Type())
+	}
+	return algos
+}
+
+// placeholderKey never matches a real host key
+type placeholderKey struct{}
+
+func (placeholderKey) Type() string                        { return "placeholder" }
+func (placeholderKey) Marshal() []byte                     { return []byte("placeholder") }
+func (placeholderKey) Verify([]byte, *ssh.Signature) error { return errors.New("placeholder key") }
+
+func appendKnownHost(path, hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to update known_hosts: %w", err)
+	}
+	return nil
+}
+
+// AddHostCA appends an @cert-authority entry trusting key to sign host certificates
+// for the given comma-separated host patterns (e.g. "*.corp.example.com")
+func AddHostCA(patterns string, key ssh.PublicKey) error {
+	path, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+
+	var hosts []string
+	for _, p := range strings.Split(patterns, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			hosts = append(hosts, p)
+		}
+	}
+	if len(hosts) == 0 {
+		return fmt.Errorf("at least one host pattern is required")
+	}
+	if _, ok := key.(*ssh.Certificate); ok {
+		return fmt.Errorf("a CA public key is required, not a certificate")
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to update known_hosts: %w", err)
+	}
+	defer f.Close()
+
+	line := "@cert-authority " + strings.Join(hosts, ",") + " " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	if _, err := f.WriteString(line + "\n"); err != nil {
+		return fmt.Errorf("failed to update known_hosts: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RDP client backends selectable per profile (Profile.RDPClient)
+const (
+	RDPClientAuto    = "" // first installed of mstsc, FreeRDP, Remmina
+	RDPClientMSTSC   = "mstsc"
+	RDPClientFreeRDP = "freerdp"
+	RDPClientRemmina = "remmina"
+	RDPClientCustom  = "custom"
+	RDPClientNone    = "none" // tunnel only; another tool connects to the local port
+)
+
+// RDPClients lists the backends in auto-detection order, with display names
+var RDPClients = []struct {
+	Kind  string
+	Label string
+}{
+	{RDPClientAuto, "Auto-detect"},
+	{RDPClientMSTSC, "Remote Desktop (mstsc)"},
+	{RDPClientFreeRDP, "FreeRDP"},
+	{RDPClientRemmina, "Remmina"},
+	{RDPClientCustom, "Custom command"},
+	{RDPClientNone, "None (tunnel only)"},
+}
+
+// freeRDPBinaries are tried in order; the Wayland client is only preferred without X11
+var freeRDPBinaries = []string{"xfreerdp3", "xfreerdp", "sdl-freerdp3", "sdl-freerdp", "wlfreerdp3", "wlfreerdp"}
+
+// LaunchSpec is what the RDP client connects to
+type LaunchSpec struct {
+	Address string // local tunnel endpoint, host:port
+	User    string
+	Title   string // profile name, used as window title where supported
+	Display RDPSettings
+}
+
+// Launcher starts an RDP client against the local tunnel endpoint
+type Launcher interface {
+	// Name identifies the client in logs
+	Name() string
+	// Command prepares the client process for spec. cleanup removes temporary files and
+	// runs when the tunnel closes; it may be nil. A nil cmd launches nothing and keeps
+	// the tunnel open until it is disconnected.
+	Command(ctx context.Context, spec LaunchSpec) (cmd *exec.Cmd, cleanup func(), err error)
+	// Detaches reports whether the process may exit while the RDP session carries on in
+	// another process (Remmina hands new connections to its running instance)
+	Detaches() bool
+}
+
+// NewLauncher returns the backend for kind, looking up its executable on PATH.
+// RDPClientAuto picks the first installed client; command is the RDPClientCustom template.
+func NewLauncher(kind, command string) (Launcher, error) {
+	switch kind {
+	case RDPClientAuto:
+		for _, k := range []string{RDPClientMSTSC, RDPClientFreeRDP, RDPClientRemmina} {
+			if l, err := NewLauncher(k, ""); err == nil {
+				return l, nil
+			}
+		}
+		return nil, fmt.Errorf("no RDP client found on PATH; install FreeRDP or Remmina, or set a custom command")
+	case RDPClientMSTSC:
+		path, err := exec.LookPath("mstsc.exe")
+		if err != nil {
+			return nil, fmt.Errorf("mstsc.exe not found: %w", err)
+		}
+		return &mstscLauncher{path: path}, nil
+	case RDPClientFreeRDP:
+		return newFreeRDPLauncher()
+	case RDPClientRemmina:
+		path, err := exec.LookPath("remmina")
+		if err != nil {
+			return nil, fmt.Errorf("remmina not found: %w", err)
+		}
+		return &remminaLauncher{path: path}, nil
+	case RDPClientCustom:
+		args, err := splitCommandLine(command)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, fmt.Errorf("custom RDP client command is empty")
+		}
+		return &customLauncher{args: args}, nil
+	case RDPClientNone:
+		return tunnelOnlyLauncher{}, nil
+	}
+	return nil, fmt.Errorf("unknown RDP client %q", kind)
+}
+
+// InstalledRDPClients reports which backends have their executable on PATH
+func InstalledRDPClients() map[string]bool {
+	installed := make(map[string]bool)
+	for _, c := range RDPClients {
+		if c.Kind == RDPClientAuto || c.Kind == RDPClientCustom || c.Kind == RDPClientNone {
+			continue
+		}
+		if _, err := NewLauncher(c.Kind, ""); err == nil {
+			installed[c.Kind] = true
+		}
+	}
+	return installed
+}
+
+// mstscLauncher opens a generated .rdp file with the Windows Remote Desktop client
+type mstscLauncher struct {
+	path string
+}
+
+func (l *mstscLauncher) Name() string   { return "mstsc" }
+func (l *mstscLauncher) Detaches() bool { return false }
+
+func (l *mstscLauncher) Command(ctx context.Context, spec LaunchSpec) (*exec.Cmd, func(), error) {
+	file, err := writeRDPFile(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return exec.CommandContext(ctx, l.path, file), func() { os.Remove(file) }, nil
+}
+
+// freeRDPLauncher passes the settings as FreeRDP command-line options
+type freeRDPLauncher struct {
+	path string
+	v3   bool // FreeRDP 3 renamed several options
+}
+
+func newFreeRDPLauncher() (*freeRDPLauncher, error) {
+	candidates := freeRDPBinaries
+	if os.Getenv("WAYLAND_DISPLAY") != "" && os.Getenv("DISPLAY") == "" {
+		candidates = []string{"wlfreerdp3", "wlfreerdp", "sdl-freerdp3", "sdl-freerdp"}
+	}
+	for _, name := range candidates {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		v3 := strings.HasSuffix(name, "3") || freeRDPMajorVersion(path) >= 3
+		return &freeRDPLauncher{path: path, v3: v3}, nil
+	}
+	return nil, fmt.Errorf("FreeRDP not found (looked for %s)", strings.Join(candidates, ", "))
+}
+
+var freeRDPVersionPattern = regexp.MustCompile(`version (\d+)\.`)
+
+// freeRDPMajorVersion asks the binary for its version, returning 0 if unknown
+func freeRDPMajorVersion(path string) int {
+	out, _ := exec.Command(path, "/version").Output()
+	m := freeRDPVersionPattern.FindSubmatch(out)
+	if m == nil {
+		return 0
+	}
+	v, _ := strconv.Atoi(string(m[1]))
+	return v
+}
+
+func (l *freeRDPLauncher) Name() string   { return filepath.Base(l.path) }
+func (l *freeRDPLauncher) Detaches() bool { return false }
+
+func (l *freeRDPLauncher) Command(ctx context.Context, spec LaunchSpec) (*exec.Cmd, func(), error) {
+	d := spec.Display
+	args := []string{"/v:" + spec.Address}
+	if spec.User != "" {
+		args = append(args, "/u:"+spec.User)
+	}
+	if spec.Title != "" {
+		args = append(args, "/t:"+spec.Title)
+	}
+	switch {
+	case d.MultiMonitor:
+		args = append(args, "/multimon", "/f")
+	case d.FullScreen:
+		args = append(args, "/f")
+	case d.Resolution() != "":
+		args = append(args, "/size:"+d.Resolution())
+	}
+	args = append(args, "/bpp:"+strconv.Itoa(d.Bpp()))
+
+	switch d.Audio {
+	case AudioRemote:
+		args = append(args, "/audio-mode:1")
+	case AudioOff:
+		args = append(args, "/audio-mode:2")
+	default:
+		args = append(args, "/sound")
+	}
+	args = append(args, plusMinus("clipboard", !d.NoClipboard), plusMinus("compression", !d.NoCompression))
+	if d.RedirectDrives {
+		args = append(args, "+home-drive")
+	}
+	if d.RedirectPrinters {
+		args = append(args, "/printer")
+	}
+
+	// The server certificate never matches "localhost"; trust it on first use like known_hosts.
+	// FreeRDP has no terminal to ask on when started from the GUI.
+	if l.v3 {
+		args = append(args, "/cert:tofu")
+	} else {
+		args = append(args, "/cert-tofu")
+	}
+	return exec.CommandContext(ctx, l.path, args...), nil, nil
+}
+
+// plusMinus formats a FreeRDP boolean option: +name or -name
+func plusMinus(name string, on bool) string {
+	if on {
+		return "+" + name
+	}
+	return "-" + name
+}
+
+// remminaLauncher opens a generated .remmina connection file
+type remminaLauncher struct {
+	path string
+}
+
+func (l *remminaLauncher) Name() string   { return "remmina" }
+func (l *remminaLauncher) Detaches() bool { return true }
+
+func (l *remminaLauncher) Command(ctx context.Context, spec LaunchSpec) (*exec.Cmd, func(), error) {
+	file, err := writeTempFile("*.remmina", []byte(renderRemminaFile(spec)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return exec.CommandContext(ctx, l.path, "-c", file), func() { os.Remove(file) }, nil
+}
+
+// renderRemminaFile builds a Remmina connection profile (INI format)
+func renderRemminaFile(spec LaunchSpec) string {
+	name := AppName
+	if spec.Title != "" {
+		name += " - " + spec.Title
+	}
+
+	d := spec.Display
+
+	// viewmode 1 = scrolled window, 4 = viewport fullscreen;
+	// resolution_mode 0 = client default, 1 = custom size
+	viewMode, resolutionMode := 1, 0
+	if d.FullScreen || d.MultiMonitor {
+		viewMode = 4
+	}
+	if d.Resolution() != "" {
+		resolutionMode = 1
+	}
+
+	sound := "local"
+	switch d.Audio {
+	case AudioRemote:
+		sound = "remote"
+	case AudioOff:
+		sound = "off"
+	}
+
+	lines := []string{
+		"[remmina]",
+		"name=" + iniValue(name),
+		"protocol=RDP",
+		"server=" + spec.Address,
+		"username=" + iniValue(spec.User),
+		"viewmode=" + strconv.Itoa(viewMode),
+		"resolution_mode=" + strconv.Itoa(resolutionMode),
+		"multimon=" + strconv.Itoa(boolInt(d.MultiMonitor)),
+		"colordepth=" + strconv.Itoa(d.Bpp()),
+		"sound=" + sound,
+		"disableclipboard=" + strconv.Itoa(boolInt(d.NoClipboard)),
+		"shareprinter=" + strconv.Itoa(boolInt(d.RedirectPrinters)),
+		"gateway_usage=0",
+	}
+	if d.RedirectDrives {
+		if home, err := os.UserHomeDir(); err == nil {
+			lines = append(lines, "sharefolder="+iniValue(home))
+		}
+	}
+	if resolutionMode == 1 {
+		lines = append(lines,
+			"resolution_width="+strconv.Itoa(d.Width),
+			"resolution_height="+strconv.Itoa(d.Height))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// iniValue strips line breaks so a value cannot start a new key
+func iniValue(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// tunnelOnlyLauncher launches no client, for tools that connect to the tunnel themselves
+type tunnelOnlyLauncher struct{}
+
+func (tunnelOnlyLauncher) Name() string   { return "none" }
+func (tunnelOnlyLauncher) Detaches() bool { return false }
+
+func (tunnelOnlyLauncher) Command(ctx context.Context, spec LaunchSpec) (*exec.Cmd, func(), error) {
+	return nil, nil, nil
+}
+
+// customLauncher runs a user-defined command template. Placeholders:
+// {address} {host} {port} {user} {profile} {width} {height} {rdpfile}
+type customLauncher struct {
+	args []string
+}
+
+func (l *customLauncher) Name() string   { return filepath.Base(l.args[0]) }
+func (l *customLauncher) Detaches() bool { return false }
+
+func (l *customLauncher) Command(ctx context.Context, spec LaunchSpec) (*exec.Cmd, func(), error) {
+	host, port, _ := net.SplitHostPort(spec.Address)
+	vars := []string{
+		"{address}", spec.Address,
+		"{host}", host,
+		"{port}", port,
+		"{user}", spec.User,
+		"{profile}", spec.Title,
+		"{width}", strconv.Itoa(spec.Display.Width),
+		"{height}", strconv.Itoa(spec.Display.Height),
+	}
+
+	var cleanup func()
+	for _, arg := range l.args {
+		if strings.Contains(arg, "{rdpfile}") {
+			file, err := writeRDPFile(spec)
+			if err != nil {
+				return nil, nil, err
+			}
+			vars = append(vars, "{rdpfile}", file)
+			cleanup = func() { os.Remove(file) }
+			break
+		}
+	}
+
+	r := strings.NewReplacer(vars...)
+	args := make([]string, len(l.args))
+	for i, arg := range l.args {
+		args[i] = r.Replace(arg)
+	}
+	return exec.CommandContext(ctx, args[0], args[1:]...), cleanup, nil
+}
+
+// splitCommandLine splits a command template into arguments on whitespace. Single or
+// double quotes group words; backslashes are literal so Windows paths work unquoted.
+func splitCommandLine(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	var quote rune
+
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in command %q", quote, s)
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
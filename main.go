@@ -1,8 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
 	"io"
 	"log"
 	"net"
@@ -14,8 +19,6 @@ import (
 	"sync"
 	"time"
 
-	_ "image/png"
-
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
 	"fyne.io/fyne/v2/canvas"
@@ -27,6 +30,7 @@ import (
 	"fyne.io/fyne/v2/theme"
 	"fyne.io/fyne/v2/widget"
 	nativeDialog "github.com/sqweek/dialog"
+	"golang.org/x/crypto/ssh"
 )
 
 
@@ -39,7 +43,7 @@ var (
 
 	connectFunc    func()
 	disconnectFunc func()
-	isTunnelActive bool
+	sessions       = &SessionManager{}
 )
 
 
@@ -53,7 +57,133 @@ func (w *uiWriter) Write(p []byte) (n int, err error) {
 	return len(p), nil
 }
 
+// guiPrompter answers handshake questions with dialogs on the main window
+type guiPrompter struct {
+	window fyne.Window
+}
+
+func (p *guiPrompter) ConfirmHostKey(hostname string, remote net.Addr, key ssh.PublicKey) bool {
+	fingerprint := ssh.FingerprintSHA256(key)
+	log.Printf("Unknown host key for %s (%s): %s %s", hostname, remote, key.Type(), fingerprint)
+
+	answer := make(chan bool, 1)
+	fyne.Do(func() {
+		msg := fmt.Sprintf("The authenticity of host %s (%s) can't be established.\n\n%s key fingerprint is:\n%s\n\nTrust this host and add it to known_hosts?",
+			hostname, remote, key.Type(), fingerprint)
+		p.window.Show()
+		p.window.RequestFocus()
+		dialog.ShowConfirm("Unknown Host Key", msg, func(ok bool) { answer <- ok }, p.window)
+	})
+
+	ok := <-answer
+	if ok {
+		log.Printf("Host key for %s trusted and saved to known_hosts.", hostname)
+	} else {
+		log.Printf("Host key for %s rejected by user.", hostname)
+	}
+	return ok
+}
+
+func (p *guiPrompter) Secret(title, message string) (string, bool) {
+	type result struct {
+		value string
+		ok    bool
+	}
+	answer := make(chan result, 1)
+	fyne.Do(func() {
+		entry := widget.NewPasswordEntry()
+		item := widget.NewFormItem("Passphrase", entry)
+		item.HintText = message
+		d := dialog.NewForm(title, "OK", "Cancel", []*widget.FormItem{item}, func(ok bool) {
+			answer <- result{entry.Text, ok}
+		}, p.window)
+		p.window.Show()
+		p.window.RequestFocus()
+		d.Resize(fyne.NewSize(360, 0))
+		d.Show()
+		p.window.Canvas().Focus(entry)
+	})
+
+	r := <-answer
+	return r.value, r.ok
+}
+
+func (p *guiPrompter) Challenge(title, message string, questions []string, echos []bool) ([]string, bool) {
+	log.Printf("%s: %s", title, strings.ReplaceAll(message, "\n", " - "))
+
+	type result struct {
+		values []string
+		ok     bool
+	}
+	answer := make(chan result, 1)
+	fyne.Do(func() {
+		items := []*widget.FormItem{widget.NewFormItem("", widget.NewLabel(message))}
+		entries := make([]*widget.Entry, len(questions))
+		for i, q := range questions {
+			if i < len(echos) && echos[i] {
+				entries[i] = widget.NewEntry()
+			} else {
+				entries[i] = widget.NewPasswordEntry()
+			}
+			items = append(items, widget.NewFormItem(strings.TrimSuffix(q, ":"), entries[i]))
+		}
+
+		d := dialog.NewForm(title, "OK", "Cancel", items, func(ok bool) {
+			values := make([]string, len(entries))
+			for i, e := range entries {
+				values[i] = e.Text
+			}
+			answer <- result{values, ok}
+		}, p.window)
+		p.window.Show()
+		p.window.RequestFocus()
+		d.Resize(fyne.NewSize(360, 0))
+		d.Show()
+		if len(entries) > 0 {
+			p.window.Canvas().Focus(entries[0])
+		}
+	})
+
+	r := <-answer
+	if !r.ok {
+		log.Printf("%s cancelled by user.", title)
+	}
+	return r.values, r.ok
+}
+
+// badgedIcon draws a dot of colour c over the bottom-right corner of a PNG icon
+func badgedIcon(res fyne.Resource, c color.Color) fyne.Resource {
+	src, err := png.Decode(bytes.NewReader(res.Content()))
+	if err != nil {
+		return res
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(b)
+	draw.Draw(img, b, src, b.Min, draw.Src)
+
+	r := b.Dx() / 4
+	cx, cy := b.Max.X-r-1, b.Max.Y-r-1
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r {
+				img.Set(x, y, c)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return res
+	}
+	return fyne.NewStaticResource("badged-"+res.Name(), buf.Bytes())
+}
+
 func main() {
+	// Subcommands run headless: no single-instance lock and no window
+	if len(os.Args) > 1 && isCLICommand(os.Args[1]) {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	// Enforce single instance via TCP listener
 	l, err := net.Listen("tcp", "127.0.0.1:44444")
 	if err != nil {
@@ -121,6 +251,7 @@ func main() {
 
 	w := a.NewWindow(fmt.Sprintf("%s %s", AppName, AppVersion))
 	w.SetFixedSize(true)
+	prompter := &guiPrompter{window: w}
 
 	updateStatus := func(msg string) {
 		statusBinding.Set(strings.ReplaceAll(msg, "\n", " "))
@@ -129,6 +260,12 @@ func main() {
 	var desk desktop.App
 	var updateTray func(string)
 
+	// trayState is the last state passed to updateTray; trayWarning, when set, badges
+	// the tray icon and heads the tray menu (expired makes the badge red)
+	trayState := "idle"
+	var trayWarning string
+	var trayWarningExpired bool
+
 	if d, ok := a.(desktop.App); ok {
 		desk = d
 		iconIdle := embeddedIconIdle
@@ -154,14 +291,14 @@ func main() {
 				connectFunc()
 			}
 		})
-		itemDisconnect := fyne.NewMenuItem("Disconnect", func() {
+		itemDisconnect := fyne.NewMenuItem("Disconnect All", func() {
 			if disconnectFunc != nil {
 				disconnectFunc()
 			}
 		})
 		itemQuit := fyne.NewMenuItem("Quit", func() {
-			if isTunnelActive {
-				dialog.ShowConfirm("Active Connection", "A tunnel is currently active. Quitting will disconnect it. Continue?", func(ok bool) {
+			if n := sessions.Active(); n > 0 {
+				dialog.ShowConfirm("Active Connection", fmt.Sprintf("%d tunnel(s) currently active. Quitting will disconnect them. Continue?", n), func(ok bool) {
 					if ok {
 						a.Quit()
 					}
@@ -172,30 +309,67 @@ func main() {
 			}
 		})
 
-		trayMenu := fyne.NewMenu("Tray",
+		itemStatus := fyne.NewMenuItem("No active sessions", nil)
+		itemStatus.Disabled = true
+		itemWarning := fyne.NewMenuItem("", func() {
+			w.Show()
+			w.RequestFocus()
+		})
+
+		trayItems := []*fyne.MenuItem{
+			itemStatus,
+			fyne.NewMenuItemSeparator(),
 			itemShow,
 			fyne.NewMenuItemSeparator(),
 			itemConnect,
 			itemDisconnect,
 			fyne.NewMenuItemSeparator(),
 			itemQuit,
-		)
+		}
+		trayMenu := fyne.NewMenu("Tray", trayItems...)
 
 		updateTray = func(state string) {
+			trayState = state
+			itemStatus.Label = "No active sessions"
+			if list := sessions.Sessions(); len(list) > 0 {
+				var parts []string
+				for _, sess := range list {
+					parts = append(parts, sess.Profile+": "+sess.Status())
+				}
+				itemStatus.Label = strings.Join(parts, ", ")
+			}
+
+			var icon fyne.Resource
 			switch state {
+			case "reconnecting":
+				icon = iconDisconnected
+				itemConnect.Disabled = false
+				itemDisconnect.Disabled = false
 			case "connected":
-				desk.SetSystemTrayIcon(iconConnected)
-				itemConnect.Disabled = true
+				icon = iconConnected
+				itemConnect.Disabled = false
 				itemDisconnect.Disabled = false
 			case "disconnected":
-				desk.SetSystemTrayIcon(iconDisconnected)
+				icon = iconDisconnected
 				itemConnect.Disabled = false
 				itemDisconnect.Disabled = true
 			default:
-				desk.SetSystemTrayIcon(iconIdle)
+				icon = iconIdle
 				itemConnect.Disabled = false
 				itemDisconnect.Disabled = true
 			}
+
+			trayMenu.Items = trayItems
+			if trayWarning != "" {
+				badge := color.NRGBA{R: 0xf5, G: 0xa6, B: 0x23, A: 0xff}
+				if trayWarningExpired {
+					badge = color.NRGBA{R: 0xe0, G: 0x30, B: 0x30, A: 0xff}
+				}
+				icon = badgedIcon(icon, badge)
+				itemWarning.Label = trayWarning
+				trayMenu.Items = append([]*fyne.MenuItem{itemWarning, fyne.NewMenuItemSeparator()}, trayItems...)
+			}
+			desk.SetSystemTrayIcon(icon)
 			trayMenu.Refresh()
 		}
 
@@ -211,38 +385,227 @@ func main() {
 		updateTray = func(s string) {}
 	}
 
+	// prof is the profile shown in the form; switching profiles reassigns it
+	prof := cfg.ActiveProfile()
+
+	// refreshForm is set once the buttons exist
+	var refreshForm func()
+
 	hostEntry := widget.NewEntry()
 	hostEntry.SetPlaceHolder("e.g. 192.168.1.100")
-	hostEntry.SetText(cfg.RemoteHost)
 
 	userEntry := widget.NewEntry()
 	userEntry.SetPlaceHolder("e.g. jdoe")
-	userEntry.SetText(cfg.RemoteUser)
+
+	sshConfigCheck := widget.NewCheck("ssh_config alias", func(on bool) {
+		if on {
+			hostEntry.SetPlaceHolder("Host alias from ~/.ssh/config")
+		} else {
+			hostEntry.SetPlaceHolder("e.g. 192.168.1.100")
+		}
+		// A username rule keeps its own placeholder
+		if prof.UsernameRule != UsernameManual {
+			return
+		}
+		if on {
+			userEntry.SetPlaceHolder("From ~/.ssh/config")
+		} else {
+			userEntry.SetPlaceHolder("e.g. jdoe")
+		}
+	})
+
+	// refreshUserRule shows where the username comes from: a profile with a username
+	// rule takes it from the certificate, so the entry is cleared and disabled
+	refreshUserRule := func() {
+		if prof.UsernameRule == UsernameManual {
+			userEntry.SetText(prof.RemoteUser)
+			if sshConfigCheck.Checked {
+				userEntry.SetPlaceHolder("From ~/.ssh/config")
+			} else {
+				userEntry.SetPlaceHolder("e.g. jdoe")
+			}
+			userEntry.Enable()
+			return
+		}
+		userEntry.SetText("")
+		userEntry.SetPlaceHolder("From certificate: " + UsernameRuleLabel(prof.UsernameRule))
+		userEntry.Disable()
+	}
+
+	jumpLabel := widget.NewLabel("")
+	jumpLabel.Truncation = fyne.TextTruncateEllipsis
+	refreshJumpLabel := func() {
+		if len(prof.JumpHosts) == 0 {
+			jumpLabel.SetText("None (direct)")
+			return
+		}
+		var names []string
+		for _, hop := range prof.JumpHosts {
+			names = append(names, hop.String())
+		}
+		jumpLabel.SetText(strings.Join(names, " → "))
+	}
+
+	forwardsLabel := widget.NewLabel("")
+	forwardsLabel.Truncation = fyne.TextTruncateEllipsis
+	refreshForwardsLabel := func() {
+		if len(prof.Forwards) == 0 {
+			forwardsLabel.SetText("None (RDP only)")
+			return
+		}
+		var names []string
+		for _, f := range prof.Forwards {
+			names = append(names, f.String())
+		}
+		forwardsLabel.SetText(strings.Join(names, ", "))
+	}
+
+	socksLabel := widget.NewLabel("")
+	socksLabel.Truncation = fyne.TextTruncateEllipsis
+	refreshSOCKSLabel := func() {
+		s := prof.SOCKS
+		if !s.Enabled {
+			socksLabel.SetText("Off")
+			return
+		}
+		text := s.ListenAddress()
+		if s.Username != "" {
+			text += ", password"
+		}
+		if len(s.Rules) > 0 {
+			text += fmt.Sprintf(", %d rules", len(s.Rules))
+		}
+		socksLabel.SetText(text)
+	}
+
+	rdpTargetEntry := widget.NewEntry()
+	rdpTargetEntry.SetPlaceHolder(DefaultRDPTarget)
 
 	localPortEntry := widget.NewEntry()
 	localPortEntry.SetPlaceHolder("e.g. 33890")
-	localPortEntry.SetText(cfg.LocalPort)
 
-	p12Label := widget.NewLabel(filepath.Base(cfg.P12Path))
-	if cfg.P12Path == "" {
-		p12Label.SetText("Select certificate file...")
+	// RDP client choices, marking backends whose executable is not on PATH
+	installedClients := InstalledRDPClients()
+	var rdpClientLabels []string
+	rdpClientKinds := make(map[string]string)
+	rdpClientLabel := make(map[string]string)
+	for _, c := range RDPClients {
+		label := c.Label
+		switch {
+		case c.Kind == RDPClientAuto:
+			if l, err := NewLauncher(RDPClientAuto, ""); err == nil {
+				label += " (" + l.Name() + ")"
+			} else {
+				label += " (none found)"
+			}
+		case c.Kind != RDPClientCustom && c.Kind != RDPClientNone && !installedClients[c.Kind]:
+			label += " (not found)"
+		}
+		rdpClientLabels = append(rdpClientLabels, label)
+		rdpClientKinds[label] = c.Kind
+		rdpClientLabel[c.Kind] = label
 	}
+	rdpClientSelect := widget.NewSelect(rdpClientLabels, func(label string) {
+		kind := rdpClientKinds[label]
+		if kind != prof.RDPClient {
+			prof.RDPClient = kind
+			_ = SaveConfig(cfg)
+			log.Printf("RDP client set to %s", label)
+			if refreshForm != nil {
+				refreshForm()
+			}
+		}
+	})
+
+	p12Label := widget.NewLabel("")
 	p12Label.Truncation = fyne.TextTruncateEllipsis
+	refreshP12Label := func() {
+		if prof.UseAgent {
+			p12Label.SetText("Not used (keys from ssh-agent)")
+		} else if prof.P12Path == "" {
+			p12Label.SetText("Select certificate file...")
+		} else {
+			p12Label.SetText(filepath.Base(prof.P12Path))
+		}
+	}
+
+	sshCertLabel := widget.NewLabel("")
+	sshCertLabel.Truncation = fyne.TextTruncateEllipsis
+	refreshSSHCertLabel := func() {
+		switch {
+		case cfg.UserCAKeyPath != "":
+			sshCertLabel.SetText("Minted by local CA (" + filepath.Base(cfg.UserCAKeyPath) + ")")
+		case prof.UserCertPath != "":
+			sshCertLabel.SetText(filepath.Base(prof.UserCertPath))
+		default:
+			sshCertLabel.SetText("None (plain public key)")
+		}
+	}
+
+	useAgentCheck := widget.NewCheck("Use ssh-agent keys", func(on bool) {
+		prof.UseAgent = on
+		refreshP12Label()
+	})
+	forwardAgentCheck := widget.NewCheck("Forward agent", func(on bool) {
+		prof.ForwardAgent = on
+	})
+
+	// showProfile fills the form from prof
+	showProfile := func() {
+		hostEntry.SetText(prof.RemoteHost)
+		userEntry.SetText(prof.RemoteUser)
+		sshConfigCheck.SetChecked(prof.UseSSHConfig)
+		rdpTargetEntry.SetText(prof.RDPTarget)
+		localPortEntry.SetText(prof.LocalPort)
+		rdpClientSelect.SetSelected(rdpClientLabel[prof.RDPClient])
+		refreshJumpLabel()
+		refreshForwardsLabel()
+		refreshSOCKSLabel()
+		useAgentCheck.SetChecked(prof.UseAgent)
+		forwardAgentCheck.SetChecked(prof.ForwardAgent)
+		refreshP12Label()
+		refreshSSHCertLabel()
+		refreshUserRule()
+	}
+
+	// storeProfile copies the form's text fields back into prof
+	storeProfile := func() {
+		prof.RemoteHost = hostEntry.Text
+		if prof.UsernameRule == UsernameManual {
+			prof.RemoteUser = userEntry.Text
+		}
+		prof.UseSSHConfig = sshConfigCheck.Checked
+		prof.UseAgent = useAgentCheck.Checked
+		prof.ForwardAgent = forwardAgentCheck.Checked
+		prof.RDPTarget = strings.TrimSpace(rdpTargetEntry.Text)
+		prof.LocalPort = localPortEntry.Text
+	}
 
 	p12PassEntry := widget.NewPasswordEntry()
 	p12PassEntry.SetPlaceHolder("Certificate Password")
 
 	status := widget.NewLabelWithData(statusBinding)
 	status.Truncation = fyne.TextTruncateEllipsis
-	statusBar := container.NewBorder(nil, nil, nil, nil, status)
+
+	// copyAddress is the endpoint of the last tunnel-only session, offered for copying
+	var copyAddress string
+	copyAddressBtn := widget.NewButtonWithIcon("Copy address", theme.ContentCopyIcon(), func() {
+		a.Clipboard().SetContent(copyAddress)
+		log.Printf("Copied %s to the clipboard.", copyAddress)
+	})
+	copyAddressBtn.Hide()
+	statusBar := container.NewBorder(nil, nil, nil, copyAddressBtn, status)
 
 	validateInputs := func() error {
 		if hostEntry.Text == "" {
 			return fmt.Errorf("remote host is required")
 		}
-		if userEntry.Text == "" {
+		if userEntry.Text == "" && !sshConfigCheck.Checked && prof.UsernameRule == UsernameManual {
 			return fmt.Errorf("ssh username is required")
 		}
+		if _, err := NormalizeRDPTarget(rdpTargetEntry.Text); err != nil {
+			return err
+		}
 		if localPortEntry.Text == "" {
 			return fmt.Errorf("local port is required")
 		}
@@ -259,6 +622,36 @@ func main() {
 		return nil
 	}
 
+	// checkExpiry looks at every profile's recorded certificate expiry: the most urgent
+	// warning badges the tray, and each newly crossed threshold is notified once
+	checkExpiry := func() []ExpiryWarning {
+		warnings := cfg.CheckExpiry(time.Now())
+
+		trayWarning, trayWarningExpired = "", false
+		if len(warnings) > 0 {
+			trayWarning = warnings[0].Message()
+			trayWarningExpired = warnings[0].Expired
+			if len(warnings) > 1 {
+				trayWarning += fmt.Sprintf(" (+%d more)", len(warnings)-1)
+			}
+		}
+		updateTray(trayState)
+
+		var fresh []ExpiryWarning
+		for _, ew := range warnings {
+			if ew.New {
+				log.Print(ew.Message())
+				a.SendNotification(fyne.NewNotification(AppName+": Certificate Expiry", ew.Message()))
+				fresh = append(fresh, ew)
+			}
+		}
+		if len(fresh) > 0 {
+			cfg.MarkExpiryNotified(fresh)
+			_ = SaveConfig(cfg)
+		}
+		return warnings
+	}
+
 	validateP12 := func() (*P12Info, error) {
 		updateStatus("Status: Validating certificate...")
 
@@ -267,7 +660,7 @@ func main() {
 			return nil, err
 		}
 
-		if cfg.P12Path == "" {
+		if prof.P12Path == "" {
 			err := fmt.Errorf("no P12 file selected")
 			updateStatus("Status: Error - " + err.Error())
 			return nil, err
@@ -278,7 +671,7 @@ func main() {
 			return nil, err
 		}
 
-		info, err := ParseP12(cfg.P12Path, p12PassEntry.Text)
+		info, err := ParseP12(prof.P12Path, p12PassEntry.Text)
 		if err != nil {
 			updateStatus("Status: Invalid Cert - " + err.Error())
 			return nil, err
@@ -292,17 +685,123 @@ func main() {
 		if info.UPN != "" {
 			log.Printf("  UPN: %s", info.UPN)
 		}
+		chain := VerifyP12Chain(info)
+		for _, line := range chain.Report() {
+			log.Printf("  %s", line)
+		}
+		notice := cfg.ExpiryNotice(info.Certificate, time.Now())
+		if notice != "" {
+			log.Printf("  Warning: %s", notice)
+		}
+		if prof.RecordCertExpiry(info) {
+			_ = SaveConfig(cfg)
+		}
+		checkExpiry()
 
 		msg := fmt.Sprintf("Status: Valid Cert (CN: %s", info.CommonName)
 		if info.UPN != "" {
 			msg += fmt.Sprintf(" | UPN: %s", info.UPN)
 		}
 		msg += ")"
+		if notice != "" {
+			msg += " - " + notice
+		} else if warning := chain.Warning(); warning != "" {
+			msg += " - " + warning
+		}
 		updateStatus(msg)
 
 		return info, nil
 	}
 
+	// validateCredentials checks the form and loads the P12, which profiles using
+	// ssh-agent keys do without (the returned info is then nil) unless their username
+	// is derived from the certificate
+	validateCredentials := func() (*P12Info, error) {
+		if !prof.UseAgent || prof.UsernameRule != UsernameManual {
+			return validateP12()
+		}
+		if err := validateInputs(); err != nil {
+			updateStatus("Status: Error - " + err.Error())
+			return nil, err
+		}
+		updateStatus("Status: Using ssh-agent keys")
+		return nil, nil
+	}
+
+	var caMutex sync.Mutex
+	var caSigner ssh.Signer
+	var caSignerPath string
+
+	// loadCASigner returns the local user CA key, asking for its passphrase once per session.
+	// Called from connection goroutines since it may block on the prompter.
+	loadCASigner := func() (ssh.Signer, error) {
+		caMutex.Lock()
+		defer caMutex.Unlock()
+
+		if caSigner != nil && caSignerPath == cfg.UserCAKeyPath {
+			return caSigner, nil
+		}
+
+		signer, err := LoadCAKey(cfg.UserCAKeyPath, nil)
+		if err == ErrCAPassphraseRequired {
+			pass, ok := prompter.Secret("SSH User CA", "Passphrase for "+filepath.Base(cfg.UserCAKeyPath))
+			if !ok {
+				return nil, fmt.Errorf("CA passphrase entry cancelled")
+			}
+			signer, err = LoadCAKey(cfg.UserCAKeyPath, []byte(pass))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		caSigner, caSignerPath = signer, cfg.UserCAKeyPath
+		return signer, nil
+	}
+
+	// loadUserCert returns the OpenSSH certificate to present for the P12 key, if any
+	loadUserCert := func(p *Profile, info *P12Info) (*ssh.Certificate, error) {
+		return ResolveUserCertificate(cfg, p, info, loadCASigner, func(s string) { log.Print(s) })
+	}
+
+	// loadSigner wraps the P12 key in the SSH user certificate, if any. Profiles using
+	// the agent have no signer of their own.
+	loadSigner := func(p *Profile, info *P12Info) (ssh.Signer, error) {
+		if p.UseAgent {
+			return nil, nil
+		}
+		if err := CheckCertValidity(info.Certificate, time.Now()); err != nil {
+			updateStatus("Status: Error - " + err.Error())
+			return nil, err
+		}
+		if cfg.Revocation.Enabled {
+			status := CheckP12Revocation(cfg.Revocation, info)
+			log.Printf("Revocation: %s", status)
+			if err := status.Enforce(cfg.Revocation); err != nil {
+				updateStatus("Status: Error - " + err.Error())
+				return nil, err
+			}
+		}
+		cert, err := loadUserCert(p, info)
+		if err != nil {
+			updateStatus("Status: SSH Certificate Error - " + err.Error())
+			return nil, err
+		}
+		return NewSigner(info, cert)
+	}
+
+	// sshUser returns the username to connect as, logging one derived from the certificate
+	sshUser := func(p *Profile, info *P12Info) (string, error) {
+		user, err := p.SSHUser(info)
+		if err != nil {
+			updateStatus("Status: Error - " + err.Error())
+			return "", err
+		}
+		if p.UsernameRule != UsernameManual {
+			log.Printf("SSH username %q from certificate (%s)", user, UsernameRuleLabel(p.UsernameRule))
+		}
+		return user, nil
+	}
+
 	showLog := func() {
 		if logWindow != nil {
 			logWindow.RequestFocus()
@@ -402,108 +901,1201 @@ func main() {
 		dialog.ShowInformation("Export Success", "Key exported successfully.", w)
 	}
 
-	openUrl := func(raw string) {
-		if u, err := url.Parse(raw); err == nil {
-			_ = fyne.CurrentApp().OpenURL(u)
+	trustHostCA := func() {
+		filename, err := nativeDialog.File().Filter("SSH Public Key", "pub").Load()
+		if err != nil {
+			if err != nativeDialog.Cancelled {
+				dialog.ShowError(err, w)
+			}
+			return
 		}
-	}
 
-	quitApp := func() {
-		if isTunnelActive {
-			dialog.ShowConfirm("Active Connection", "A tunnel is currently active. Quitting will disconnect it. Continue?", func(ok bool) {
-				if ok {
-					a.Quit()
-				}
-			}, w)
-		} else {
-			a.Quit()
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("invalid CA public key: %v", err), w)
+			return
 		}
-	}
-
-	fileMenu := fyne.NewMenu("File",
-		fyne.NewMenuItem("View Activity Log", showLog),
-		fyne.NewMenuItemSeparator(),
-		fyne.NewMenuItem("Export Private Key", func() { exportKey(true) }),
-		fyne.NewMenuItem("Export Public Key", func() { exportKey(false) }),
-		fyne.NewMenuItemSeparator(),
-		fyne.NewMenuItem("Quit", quitApp),
-	)
-	helpMenu := fyne.NewMenu("Help",
-		fyne.NewMenuItem("Documentation", func() { openUrl(DocURL) }),
-		fyne.NewMenuItem("Report Issue", func() { openUrl(IssueURL) }),
-		fyne.NewMenuItemSeparator(),
-		fyne.NewMenuItem("About", func() {
-			dialog.ShowInformation(AppName+" "+AppVersion, AboutText, w)
-		}),
-	)
-	w.SetMainMenu(fyne.NewMainMenu(fileMenu, helpMenu))
-
-	var headerIcon *canvas.Image
-	if appIcon != nil {
-		headerIcon = canvas.NewImageFromResource(appIcon)
-		headerIcon.FillMode = canvas.ImageFillContain
-		headerIcon.SetMinSize(fyne.NewSize(49, 48))
-	}
 
-	title := canvas.NewText(AppName, theme.Color(theme.ColorNameForeground))
-	title.TextSize = 24
-	title.TextStyle = fyne.TextStyle{Bold: true}
+		patternEntry := widget.NewEntry()
+		patternEntry.SetPlaceHolder("e.g. *.corp.example.com")
 
-	var headerTop *fyne.Container
-	if headerIcon != nil {
-		headerTop = container.NewHBox(headerIcon, title)
-	} else {
-		headerTop = container.NewHBox(title)
+		items := []*widget.FormItem{
+			widget.NewFormItem("CA Key", widget.NewLabel(key.Type()+" "+ssh.FingerprintSHA256(key))),
+			widget.NewFormItem("Host Patterns", patternEntry),
+		}
+		dialog.ShowForm("Trust Host CA", "Trust", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := AddHostCA(patternEntry.Text, key); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			log.Printf("Trusted host CA %s for %s", ssh.FingerprintSHA256(key), patternEntry.Text)
+		}, w)
 	}
 
-	desc := widget.NewLabel("Connect to a remote host via RDP tunneled through SSH.")
-	desc.Wrapping = fyne.TextWrapWord
-	header := container.NewVBox(headerTop, widget.NewSeparator(), desc)
+	var selectProfile func(p *Profile)
 
-	browse := widget.NewButton("...", func() {
-		filename, err := nativeDialog.File().Filter("PKCS#12 Certificate", "p12", "pfx").Load()
+	importRDP := func() {
+		filename, err := nativeDialog.File().Filter("Remote Desktop File", "rdp").Load()
 		if err != nil {
 			if err != nativeDialog.Cancelled {
 				dialog.ShowError(err, w)
 			}
 			return
 		}
-		cfg.P12Path = filename
-		p12Label.SetText(filepath.Base(filename))
-		updateStatus("Status: Certificate selected. Enter password and click Test.")
-		log.Printf("Selected certificate file: %s", filename)
-	})
 
-	p12Row := container.NewBorder(nil, nil, nil, browse, p12Label)
+		imp, err := ImportRDPFile(cfg, filename, "")
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("import failed: %v", err), w)
+			return
+		}
+		storeProfile()
+		if err := cfg.ImportProfile(imp.Profile); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		selectProfile(imp.Profile)
 
-	grid := container.NewGridWithColumns(2)
+		for _, line := range imp.Summary() {
+			log.Print(line)
+		}
 
-	add := func(label string, input fyne.CanvasObject) {
-		lbl := widget.NewLabel(label)
-		lbl.Alignment = fyne.TextAlignLeading
-		grid.Add(lbl)
-		grid.Add(input)
+		msg := fmt.Sprintf("Created profile %q for %s.\nSet the SSH host and username to use it.", imp.Profile.Name, imp.Profile.RDPTarget)
+		if dropped := imp.DroppedSettings(); len(dropped) > 0 {
+			msg += fmt.Sprintf("\n\n%d setting(s) could not be carried over:\n%s", len(dropped), strings.Join(dropped, "\n"))
+		}
+		dialog.ShowInformation("Import .rdp File", msg, w)
 	}
 
-	add("Remote Host", hostEntry)
-	add("SSH Username", userEntry)
-	add("Local Port", localPortEntry)
-	add("Certificate File", p12Row)
-	add("Certificate Password", p12PassEntry)
-
-	setInputsEnabled := func(enabled bool) {
-		if enabled {
-			hostEntry.Enable()
-			userEntry.Enable()
-			localPortEntry.Enable()
-			p12PassEntry.Enable()
+	importSSHConfig := func() {
+		path, err := DefaultSSHConfigPath()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		sc, err := LoadSSHConfig(path)
+		if err != nil {
+			dialog.ShowError(fmt.Errorf("failed to read %s: %v", path, err), w)
+			return
+		}
+		hosts := sc.Hosts()
+		if len(hosts) == 0 {
+			dialog.ShowInformation("Import from SSH Config", "No Host entries found in "+path, w)
+			return
+		}
+
+		checks := container.NewVBox()
+		for _, h := range hosts {
+			checks.Add(widget.NewCheck(h, nil))
+		}
+		scroll := container.NewVScroll(checks)
+		scroll.SetMinSize(fyne.NewSize(300, 200))
+
+		liveCheck := widget.NewCheck("Resolve on each connect (keep as ssh_config alias)", nil)
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Hosts", scroll),
+			widget.NewFormItem("", liveCheck),
+		}
+		d := dialog.NewForm("Import from SSH Config", "Import", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			storeProfile()
+
+			var created []*Profile
+			var report []string
+			for _, obj := range checks.Objects {
+				check := obj.(*widget.Check)
+				if !check.Checked {
+					continue
+				}
+				alias := check.Text
+				p, notes, err := sc.ProfileFromSSHConfig(alias, cfg.UniqueProfileName(alias), liveCheck.Checked)
+				if err == nil {
+					err = cfg.ImportProfile(p)
+				}
+				if err != nil {
+					report = append(report, fmt.Sprintf("%s: %v", alias, err))
+					continue
+				}
+				created = append(created, p)
+				log.Printf("Imported profile %q from %s: %s", p.Name, path, p.RemoteHost)
+				for _, note := range notes {
+					log.Printf("  %s", note)
+					report = append(report, alias+": "+note)
+				}
+			}
+			if len(created) == 0 {
+				return
+			}
+			selectProfile(created[0])
+
+			for _, u := range sc.Unsupported {
+				log.Printf("  Unsupported directive %s", u)
+			}
+			msg := fmt.Sprintf("Created %d profile(s).", len(created))
+			if len(report) > 0 {
+				msg += "\n\n" + strings.Join(report, "\n")
+			}
+			if len(sc.Unsupported) > 0 {
+				msg += fmt.Sprintf("\n\n%d unsupported directive(s) in the file were ignored; see the activity log.", len(sc.Unsupported))
+			}
+			dialog.ShowInformation("Import from SSH Config", msg, w)
+		}, w)
+		d.Resize(fyne.NewSize(480, 400))
+		d.Show()
+	}
+
+	exportSSHCert := func() {
+		info, err := validateP12()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		go func() {
+			cert, err := loadUserCert(prof, info)
+			if err == nil && cert == nil {
+				err = fmt.Errorf("no SSH certificate configured; set a certificate file or a local user CA")
+			}
+
+			fyne.Do(func() {
+				if err != nil {
+					dialog.ShowError(fmt.Errorf("export failed: %v", err), w)
+					return
+				}
+
+				defaultName := "id_ecdsa-cert.pub"
+				switch cert.Key.Type() {
+				case ssh.KeyAlgoRSA:
+					defaultName = "id_rsa-cert.pub"
+				case ssh.KeyAlgoED25519:
+					defaultName = "id_ed25519-cert.pub"
+				}
+
+				filename, err := nativeDialog.File().SetStartFile(defaultName).Save()
+				if err != nil {
+					if err != nativeDialog.Cancelled {
+						dialog.ShowError(err, w)
+					}
+					return
+				}
+
+				// A certificate is public, so it gets the same 0644 as the CLI's public exports
+				if err := os.WriteFile(filename, ssh.MarshalAuthorizedKey(cert), 0644); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				log.Printf("SSH certificate exported to %s", filename)
+				dialog.ShowInformation("Export Success", "SSH certificate exported successfully.", w)
+			})
+		}()
+	}
+
+	addKeyToAgent := func() {
+		info, err := validateP12()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+
+		lifetimeEntry := widget.NewEntry()
+		lifetimeEntry.SetPlaceHolder(DefaultAgentLifetime.String())
+		item := widget.NewFormItem("Lifetime", lifetimeEntry)
+		item.HintText = "The agent forgets the key after this long, e.g. 30m or 8h"
+
+		d := dialog.NewForm("Add Key to SSH Agent", "Add", "Cancel", []*widget.FormItem{item}, func(ok bool) {
+			if !ok {
+				return
+			}
+			lifetime, err := ParseAgentLifetime(lifetimeEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			p := prof
+			go func() {
+				cert, err := loadUserCert(p, info)
+				if err == nil {
+					var keyring *SSHAgent
+					if keyring, err = OpenAgent(); err == nil {
+						err = AddToAgent(keyring, info, cert, lifetime)
+						keyring.Close()
+					}
+				}
+
+				fyne.Do(func() {
+					if err != nil {
+						log.Printf("Adding key to ssh-agent failed: %v", err)
+						dialog.ShowError(err, w)
+						return
+					}
+					what := "Key"
+					if cert != nil {
+						what = "Key and SSH certificate"
+					}
+					log.Printf("%s added to ssh-agent for %s.", what, lifetime)
+					dialog.ShowInformation("SSH Agent", fmt.Sprintf("%s added to ssh-agent for %s.", what, lifetime), w)
+				})
+			}()
+		}, w)
+		d.Resize(fyne.NewSize(360, 0))
+		d.Show()
+	}
+
+	showCertDetails := func() {
+		info, err := validateP12()
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		cert := info.Certificate
+		chain := VerifyP12Chain(info)
+		usages, extUsages := KeyUsages(cert)
+
+		var path []string
+		for i, c := range chain.Path {
+			path = append(path, fmt.Sprintf("%s%s (expires %s)", strings.Repeat("    ", i), certName(c), c.NotAfter.Format("2006-01-02")))
+		}
+		orNone := func(s []string) string {
+			if len(s) == 0 {
+				return "None"
+			}
+			return strings.Join(s, ", ")
+		}
+		upn := info.UPN
+		if upn == "" {
+			upn = "None"
+		}
+		validity := fmt.Sprintf("%s to %s", cert.NotBefore.Format(time.DateTime), cert.NotAfter.Format(time.DateTime))
+		if notice := cfg.ExpiryNotice(cert, time.Now()); notice != "" {
+			validity += "\n" + strings.ToUpper(notice[:1]) + notice[1:]
+		}
+		value := func(s string) *widget.Label {
+			l := widget.NewLabel(s)
+			l.Wrapping = fyne.TextWrapWord
+			return l
+		}
+
+		// OCSP and CRL fetches can be slow, so the row fills in when they finish
+		revocationLabel := value("Not checked (enable in File → Revocation Checking...)")
+		if cfg.Revocation.Enabled {
+			revocationLabel.SetText("Checking...")
+			settings := cfg.Revocation
+			go func() {
+				status := CheckP12Revocation(settings, info)
+				log.Printf("Revocation: %s", status)
+				fyne.Do(func() { revocationLabel.SetText(status.String()) })
+			}()
+		}
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Subject", value(cert.Subject.String())),
+			widget.NewFormItem("Issuer", value(cert.Issuer.String())),
+			widget.NewFormItem("Serial", value(cert.SerialNumber.String())),
+			widget.NewFormItem("Valid", value(validity)),
+			widget.NewFormItem("UPN", value(upn)),
+			widget.NewFormItem("Key", value(PublicKeyDescription(cert))),
+			widget.NewFormItem("Key Usage", value(orNone(usages))),
+			widget.NewFormItem("Extended Key Usage", value(orNone(extUsages))),
+			widget.NewFormItem("SHA-256", value(CertFingerprint(cert))),
+			widget.NewFormItem("Chain", value(strings.Join(path, "\n"))),
+			widget.NewFormItem("Verification", value(chain.Result())),
+			widget.NewFormItem("Trust Store", value(fmt.Sprintf("%s (%d certificates)", chain.RootsPath, chain.Roots))),
+			widget.NewFormItem("Revocation", revocationLabel),
+		}
+
+		d := dialog.NewCustom("Certificate Details", "Close", container.NewVScroll(widget.NewForm(items...)), w)
+		d.Resize(fyne.NewSize(600, 560))
+		d.Show()
+	}
+
+	trustRootCA := func() {
+		filename, err := nativeDialog.File().Filter("CA Certificate", "pem", "crt", "cer", "der").Load()
+		if err != nil {
+			if err != nativeDialog.Cancelled {
+				dialog.ShowError(err, w)
+			}
+			return
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		certs, err := ParseCertificates(data)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		cas := certs[:0]
+		var names []string
+		for _, c := range certs {
+			if c.IsCA {
+				cas = append(cas, c)
+				names = append(names, fmt.Sprintf("%s\nSHA-256 %s", c.Subject, CertFingerprint(c)))
+			}
+		}
+		if len(cas) == 0 {
+			dialog.ShowError(fmt.Errorf("%s contains no CA certificates", filepath.Base(filename)), w)
+			return
+		}
+
+		msg := "Trust these CAs to issue P12 certificates?\n\n" + strings.Join(names, "\n\n")
+		dialog.ShowConfirm("Trust Root CA", msg, func(ok bool) {
+			if !ok {
+				return
+			}
+			for _, c := range cas {
+				if err := AddTrustedRoot(c); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				log.Printf("Trusted root CA %s (SHA-256 %s)", c.Subject, CertFingerprint(c))
+			}
+		}, w)
+	}
+
+	configureUserCA := func() {
+		caPathEntry := widget.NewEntry()
+		caPathEntry.SetPlaceHolder("Disabled")
+		caPathEntry.SetText(cfg.UserCAKeyPath)
+
+		caBrowse := widget.NewButton("...", func() {
+			filename, err := nativeDialog.File().Load()
+			if err != nil {
+				if err != nativeDialog.Cancelled {
+					dialog.ShowError(err, w)
+				}
+				return
+			}
+			caPathEntry.SetText(filename)
+		})
+
+		validityEntry := widget.NewEntry()
+		validityEntry.SetPlaceHolder("e.g. 8h")
+		validityEntry.SetText(cfg.UserCAValidity)
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("CA Private Key", container.NewBorder(nil, nil, nil, caBrowse, caPathEntry)),
+			widget.NewFormItem("Validity", validityEntry),
+		}
+		items[0].HintText = "Leave empty to disable certificate minting"
+
+		d := dialog.NewForm("SSH User CA", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			validity, err := ParseCertValidity(validityEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			cfg.UserCAKeyPath = strings.TrimSpace(caPathEntry.Text)
+			cfg.UserCAValidity = strings.TrimSpace(validityEntry.Text)
+			_ = SaveConfig(cfg)
+			refreshSSHCertLabel()
+
+			if cfg.UserCAKeyPath != "" {
+				log.Printf("Local user CA enabled: %s (validity %s)", cfg.UserCAKeyPath, validity)
+			} else {
+				log.Print("Local user CA disabled.")
+			}
+		}, w)
+		d.Resize(fyne.NewSize(440, 0))
+		d.Show()
+	}
+
+	configureProxy := func() {
+		modes := []string{"Direct", "From environment", "HTTP", "HTTPS", "SOCKS5"}
+		schemes := map[string]string{"HTTP": "http", "HTTPS": "https", "SOCKS5": "socks5"}
+
+		addrEntry := widget.NewEntry()
+		addrEntry.SetPlaceHolder("proxy.example.com:8080")
+		proxyUserEntry := widget.NewEntry()
+		proxyUserEntry.SetPlaceHolder("Optional")
+		proxyPassEntry := widget.NewPasswordEntry()
+		proxyPassEntry.SetPlaceHolder("Optional")
+
+		modeSelect := widget.NewSelect(modes, func(mode string) {
+			if schemes[mode] == "" {
+				addrEntry.Disable()
+				proxyUserEntry.Disable()
+				proxyPassEntry.Disable()
+			} else {
+				addrEntry.Enable()
+				proxyUserEntry.Enable()
+				proxyPassEntry.Enable()
+			}
+		})
+
+		current, _ := ParseProxy(cfg.Proxy)
+		switch {
+		case cfg.Proxy == ProxyEnvironment:
+			modeSelect.SetSelected("From environment")
+		case current != nil:
+			modeSelect.SetSelected(map[string]string{"http": "HTTP", "https": "HTTPS", "socks5": "SOCKS5", "socks5h": "SOCKS5"}[current.Scheme])
+			addrEntry.SetText(current.Host)
+			if current.User != nil {
+				proxyUserEntry.SetText(current.User.Username())
+				pass, _ := current.User.Password()
+				proxyPassEntry.SetText(pass)
+			}
+		default:
+			modeSelect.SetSelected("Direct")
+		}
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Proxy", modeSelect),
+			widget.NewFormItem("Address", addrEntry),
+			widget.NewFormItem("Username", proxyUserEntry),
+			widget.NewFormItem("Password", proxyPassEntry),
+		}
+		items[0].HintText = "From environment uses HTTPS_PROXY or ALL_PROXY and NO_PROXY"
+
+		d := dialog.NewForm("Outbound Proxy", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+
+			setting := ""
+			switch mode := modeSelect.Selected; mode {
+			case "From environment":
+				setting = ProxyEnvironment
+			case "HTTP", "HTTPS", "SOCKS5":
+				u := &url.URL{Scheme: schemes[mode], Host: strings.TrimSpace(addrEntry.Text)}
+				if user := strings.TrimSpace(proxyUserEntry.Text); user != "" {
+					u.User = url.UserPassword(user, proxyPassEntry.Text)
+				}
+				if _, err := ParseProxy(u.String()); err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
+				setting = u.String()
+			}
+
+			cfg.Proxy = setting
+			_ = SaveConfig(cfg)
+
+			if u, _ := ParseProxy(setting); u != nil {
+				log.Printf("Outbound proxy set to %s", u.Redacted())
+			} else if setting == ProxyEnvironment {
+				log.Print("Outbound proxy taken from the environment.")
+			} else {
+				log.Print("Outbound proxy disabled; SSH connects directly.")
+			}
+		}, w)
+		d.Resize(fyne.NewSize(440, 0))
+		d.Show()
+	}
+
+	configureRevocation := func() {
+		enabledCheck := widget.NewCheck("Check CRL and OCSP before connecting", nil)
+		enabledCheck.SetChecked(cfg.Revocation.Enabled)
+		requireCheck := widget.NewCheck("Refuse when no responder can be reached", nil)
+		requireCheck.SetChecked(cfg.Revocation.RequireStatus)
+		graceEntry := widget.NewEntry()
+		graceEntry.SetPlaceHolder(DefaultRevocationGrace.String())
+		graceEntry.SetText(cfg.Revocation.Grace)
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Revocation", enabledCheck),
+			widget.NewFormItem("Offline Grace", graceEntry),
+			widget.NewFormItem("Unknown Status", requireCheck),
+		}
+		items[1].HintText = "How long an expired cached answer is used while offline"
+
+		d := dialog.NewForm("Revocation Checking", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			if _, err := ParseRevocationGrace(graceEntry.Text); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			cfg.Revocation = RevocationSettings{
+				Enabled:       enabledCheck.Checked,
+				Grace:         strings.TrimSpace(graceEntry.Text),
+				RequireStatus: requireCheck.Checked,
+			}
+			_ = SaveConfig(cfg)
+			if cfg.Revocation.Enabled {
+				log.Print("Certificate revocation checking enabled.")
+			} else {
+				log.Print("Certificate revocation checking disabled.")
+			}
+		}, w)
+		d.Resize(fyne.NewSize(440, 0))
+		d.Show()
+	}
+
+	configureExpiryWarnings := func() {
+		daysEntry := widget.NewEntry()
+		daysEntry.SetPlaceHolder(FormatExpiryWarningDays(DefaultExpiryWarningDays))
+		daysEntry.SetText(FormatExpiryWarningDays(cfg.ExpiryWarningDays))
+
+		item := widget.NewFormItem("Warn Before (days)", daysEntry)
+		item.HintText = "Comma-separated, e.g. 30,7,1; each threshold is notified once"
+
+		d := dialog.NewForm("Certificate Expiry Warnings", "Save", "Cancel", []*widget.FormItem{item}, func(ok bool) {
+			if !ok {
+				return
+			}
+			days, err := ParseExpiryWarningDays(daysEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			cfg.ExpiryWarningDays = days
+			_ = SaveConfig(cfg)
+			log.Printf("Certificate expiry warnings at %s days before expiry.", FormatExpiryWarningDays(cfg.ExpiryThresholds()))
+			checkExpiry()
+		}, w)
+		d.Resize(fyne.NewSize(440, 0))
+		d.Show()
+	}
+
+	openUrl := func(raw string) {
+		if u, err := url.Parse(raw); err == nil {
+			_ = fyne.CurrentApp().OpenURL(u)
+		}
+	}
+
+	quitApp := func() {
+		if n := sessions.Active(); n > 0 {
+			dialog.ShowConfirm("Active Connection", fmt.Sprintf("%d tunnel(s) currently active. Quitting will disconnect them. Continue?", n), func(ok bool) {
+				if ok {
+					a.Quit()
+				}
+			}, w)
+		} else {
+			a.Quit()
+		}
+	}
+
+	fileMenu := fyne.NewMenu("File",
+		fyne.NewMenuItem("View Activity Log", showLog),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Import .rdp File...", importRDP),
+		fyne.NewMenuItem("Import from SSH Config...", importSSHConfig),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Export Private Key", func() { exportKey(true) }),
+		fyne.NewMenuItem("Export Public Key", func() { exportKey(false) }),
+		fyne.NewMenuItem("Export SSH Certificate", exportSSHCert),
+		fyne.NewMenuItem("Add Key to SSH Agent...", addKeyToAgent),
+		fyne.NewMenuItem("Certificate Details...", showCertDetails),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("SSH User CA...", configureUserCA),
+		fyne.NewMenuItem("Trust Host CA...", trustHostCA),
+		fyne.NewMenuItem("Trust Root CA...", trustRootCA),
+		fyne.NewMenuItem("Outbound Proxy...", configureProxy),
+		fyne.NewMenuItem("Expiry Warnings...", configureExpiryWarnings),
+		fyne.NewMenuItem("Revocation Checking...", configureRevocation),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("Quit", quitApp),
+	)
+	helpMenu := fyne.NewMenu("Help",
+		fyne.NewMenuItem("Documentation", func() { openUrl(DocURL) }),
+		fyne.NewMenuItem("Report Issue", func() { openUrl(IssueURL) }),
+		fyne.NewMenuItemSeparator(),
+		fyne.NewMenuItem("About", func() {
+			dialog.ShowInformation(AppName+" "+AppVersion, AboutText, w)
+		}),
+	)
+	w.SetMainMenu(fyne.NewMainMenu(fileMenu, helpMenu))
+
+	var headerIcon *canvas.Image
+	if appIcon != nil {
+		headerIcon = canvas.NewImageFromResource(appIcon)
+		headerIcon.FillMode = canvas.ImageFillContain
+		headerIcon.SetMinSize(fyne.NewSize(49, 48))
+	}
+
+	title := canvas.NewText(AppName, theme.Color(theme.ColorNameForeground))
+	title.TextSize = 24
+	title.TextStyle = fyne.TextStyle{Bold: true}
+
+	var headerTop *fyne.Container
+	if headerIcon != nil {
+		headerTop = container.NewHBox(headerIcon, title)
+	} else {
+		headerTop = container.NewHBox(title)
+	}
+
+	desc := widget.NewLabel("Connect to a remote host via RDP tunneled through SSH.")
+	desc.Wrapping = fyne.TextWrapWord
+	header := container.NewVBox(headerTop, widget.NewSeparator(), desc)
+
+	browse := widget.NewButton("...", func() {
+		filename, err := nativeDialog.File().Filter("PKCS#12 Certificate", "p12", "pfx").Load()
+		if err != nil {
+			if err != nativeDialog.Cancelled {
+				dialog.ShowError(err, w)
+			}
+			return
+		}
+		prof.P12Path = filename
+		refreshP12Label()
+		_ = SaveConfig(cfg)
+		updateStatus("Status: Certificate selected. Enter password and click Test.")
+		log.Printf("Selected certificate file: %s", filename)
+	})
+
+	p12Row := container.NewBorder(nil, nil, nil, browse, p12Label)
+
+	sshCertBrowse := widget.NewButton("...", func() {
+		filename, err := nativeDialog.File().Filter("OpenSSH Certificate", "pub").Load()
+		if err != nil {
+			if err != nativeDialog.Cancelled {
+				dialog.ShowError(err, w)
+			}
+			return
+		}
+		prof.UserCertPath = filename
+		refreshSSHCertLabel()
+		_ = SaveConfig(cfg)
+		log.Printf("Selected SSH user certificate: %s", filename)
+	})
+	sshCertClear := widget.NewButtonWithIcon("", theme.ContentClearIcon(), func() {
+		prof.UserCertPath = ""
+		refreshSSHCertLabel()
+		_ = SaveConfig(cfg)
+		log.Print("SSH user certificate cleared.")
+	})
+
+	jumpEdit := widget.NewButton("...", func() {
+		hopsEntry := widget.NewMultiLineEntry()
+		hopsEntry.SetPlaceHolder("admin@bastion.example.com:22\njump2.internal C:\\Users\\me\\.ssh\\id_ed25519")
+		hopsEntry.SetText(FormatJumpHosts(prof.JumpHosts))
+		hopsEntry.SetMinRowsVisible(4)
+
+		item := widget.NewFormItem("Hops", hopsEntry)
+		item.HintText = "One per line, in dial order: [user@]host[:port] [private key file]. Without a key file the certificate is used."
+
+		d := dialog.NewForm("Jump Hosts", "Save", "Cancel", []*widget.FormItem{item}, func(ok bool) {
+			if !ok {
+				return
+			}
+			hops, err := ParseJumpHosts(hopsEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			prof.JumpHosts = hops
+			_ = SaveConfig(cfg)
+			refreshJumpLabel()
+			log.Printf("Jump hosts set to: %s", jumpLabel.Text)
+		}, w)
+		d.Resize(fyne.NewSize(460, 0))
+		d.Show()
+	})
+
+	jumpRow := container.NewBorder(nil, nil, nil, jumpEdit, jumpLabel)
+
+	userRuleBtn := widget.NewButton("...", func() {
+		labels := make([]string, len(UsernameRules))
+		for i, r := range UsernameRules {
+			labels[i] = r.Label
+		}
+		regexEntry := widget.NewEntry()
+		regexEntry.SetPlaceHolder("e.g. CN=([^,]+)")
+		regexEntry.SetText(prof.UsernameRegex)
+		ruleSelect := widget.NewSelect(labels, func(label string) {
+			if label == UsernameRuleLabel(UsernameFromRegex) {
+				regexEntry.Enable()
+			} else {
+				regexEntry.Disable()
+			}
+		})
+		ruleSelect.SetSelected(UsernameRuleLabel(prof.UsernameRule))
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Username", ruleSelect),
+			widget.NewFormItem("Regex", regexEntry),
+		}
+		items[0].HintText = "Where the SSH and RDP username comes from"
+		items[1].HintText = "Matched against the subject DN, e.g. CN=John Doe,OU=Users,O=Corp; the capture group is the username"
+
+		d := dialog.NewForm("SSH Username", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			rule := UsernameRules[ruleSelect.SelectedIndex()].Rule
+			pattern := ""
+			if rule == UsernameFromRegex {
+				pattern = strings.TrimSpace(regexEntry.Text)
+			}
+			if err := ValidateUsernameRule(rule, pattern); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+
+			storeProfile()
+			prof.UsernameRule, prof.UsernameRegex = rule, pattern
+			refreshUserRule()
+			_ = SaveConfig(cfg)
+			if rule == UsernameManual {
+				log.Print("SSH username is entered manually.")
+			} else {
+				log.Printf("SSH username is derived from the certificate: %s", UsernameRuleLabel(rule))
+			}
+		}, w)
+		d.Resize(fyne.NewSize(460, 0))
+		d.Show()
+	})
+
+	forwardsEdit := widget.NewButton("...", func() {
+		forwardsEntry := widget.NewMultiLineEntry()
+		forwardsEntry.SetPlaceHolder("L 5985:winrm01:5985\nR 8080:localhost:80\nD 1080")
+		forwardsEntry.SetText(FormatForwards(prof.Forwards))
+		forwardsEntry.SetMinRowsVisible(4)
+
+		item := widget.NewFormItem("Forwards", forwardsEntry)
+		item.HintText = "One per line: L [bind:]port:host:hostport (local), R [bind:]port:host:hostport (on the server) or D [bind:]port (SOCKS5 proxy)."
+
+		d := dialog.NewForm("Port Forwards", "Save", "Cancel", []*widget.FormItem{item}, func(ok bool) {
+			if !ok {
+				return
+			}
+			forwards, err := ParseForwards(forwardsEntry.Text)
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			prof.Forwards = forwards
+			_ = SaveConfig(cfg)
+			refreshForwardsLabel()
+			log.Printf("Forwards set to: %s", forwardsLabel.Text)
+		}, w)
+		d.Resize(fyne.NewSize(460, 0))
+		d.Show()
+	})
+
+	forwardsRow := container.NewBorder(nil, nil, nil, forwardsEdit, forwardsLabel)
+
+	socksEdit := widget.NewButton("...", func() {
+		cur := prof.SOCKS
+
+		enabledCheck := widget.NewCheck("Start with the tunnel", nil)
+		enabledCheck.SetChecked(cur.Enabled)
+
+		portEntry := widget.NewEntry()
+		portEntry.SetPlaceHolder(DefaultSOCKSPort)
+		portEntry.SetText(cur.Port)
+
+		socksUserEntry := widget.NewEntry()
+		socksUserEntry.SetPlaceHolder("None (no authentication)")
+		socksUserEntry.SetText(cur.Username)
+
+		socksPassEntry := widget.NewPasswordEntry()
+		socksPassEntry.SetText(cur.Password)
+
+		rulesEntry := widget.NewMultiLineEntry()
+		rulesEntry.SetPlaceHolder("allow *.corp.example.com:443\nallow 10.20.0.0/16\ndeny *")
+		rulesEntry.SetText(strings.Join(cur.Rules, "\n"))
+		rulesEntry.SetMinRowsVisible(4)
+
+		rulesItem := widget.NewFormItem("Rules", rulesEntry)
+		rulesItem.HintText = "allow or deny HOST[:PORT], first match wins. HOST is a name pattern, IP or CIDR. IP and CIDR deny rules also deny every host name, so put name rules first. Unmatched destinations are denied if any allow rule exists."
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("SOCKS5 Proxy", enabledCheck),
+			widget.NewFormItem("Local Port", portEntry),
+			widget.NewFormItem("Username", socksUserEntry),
+			widget.NewFormItem("Password", socksPassEntry),
+			rulesItem,
+		}
+		d := dialog.NewForm("SOCKS Proxy", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			s := SOCKSSettings{
+				Enabled:  enabledCheck.Checked,
+				Port:     strings.TrimSpace(portEntry.Text),
+				Username: strings.TrimSpace(socksUserEntry.Text),
+				Password: socksPassEntry.Text,
+			}
+			for _, line := range strings.Split(rulesEntry.Text, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					s.Rules = append(s.Rules, line)
+				}
+			}
+			if n, err := strconv.Atoi(s.Port); s.Port != "" && (err != nil || n < 1 || n > 65535) {
+				dialog.ShowError(fmt.Errorf("invalid SOCKS port %q", s.Port), w)
+				return
+			}
+			if s.Username != "" && s.Password == "" {
+				dialog.ShowError(fmt.Errorf("a SOCKS username needs a password"), w)
+				return
+			}
+			if _, err := NewSOCKSServer(s); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			prof.SOCKS = s
+			_ = SaveConfig(cfg)
+			refreshSOCKSLabel()
+			log.Printf("SOCKS proxy set to: %s", socksLabel.Text)
+		}, w)
+		d.Resize(fyne.NewSize(460, 0))
+		d.Show()
+	})
+
+	socksRow := container.NewBorder(nil, nil, nil, socksEdit, socksLabel)
+
+	rdpClientEdit := widget.NewButton("...", func() {
+		cur := prof.Display
+
+		screenSelect := widget.NewSelect([]string{"Window", "Full screen", "All monitors"}, nil)
+		switch {
+		case cur.MultiMonitor:
+			screenSelect.SetSelected("All monitors")
+		case cur.FullScreen:
+			screenSelect.SetSelected("Full screen")
+		default:
+			screenSelect.SetSelected("Window")
+		}
+
+		resolutionEntry := widget.NewEntry()
+		resolutionEntry.SetPlaceHolder("Client default, e.g. 1920x1080")
+		resolutionEntry.SetText(cur.Resolution())
+
+		colorSelect := widget.NewSelect([]string{"32 bit", "24 bit", "16 bit", "15 bit"}, nil)
+		colorSelect.SetSelected(fmt.Sprintf("%d bit", cur.Bpp()))
+
+		audioLabels := []string{"Play on this computer", "Play on remote computer", "Do not play"}
+		audioValues := []string{AudioLocal, AudioRemote, AudioOff}
+		audioSelect := widget.NewSelect(audioLabels, nil)
+		audioSelect.SetSelectedIndex(0)
+		for i, v := range audioValues {
+			if cur.Audio == v {
+				audioSelect.SetSelectedIndex(i)
+			}
+		}
+
+		clipboardCheck := widget.NewCheck("Clipboard", nil)
+		clipboardCheck.SetChecked(!cur.NoClipboard)
+		drivesCheck := widget.NewCheck("Drives", nil)
+		drivesCheck.SetChecked(cur.RedirectDrives)
+		printersCheck := widget.NewCheck("Printers", nil)
+		printersCheck.SetChecked(cur.RedirectPrinters)
+
+		compressionCheck := widget.NewCheck("Compress data", nil)
+		compressionCheck.SetChecked(!cur.NoCompression)
+
+		authLabels := []string{"Warn me", "Connect without warning", "Do not connect"}
+		authValues := []string{AuthWarn, AuthNone, AuthRequired}
+		authSelect := widget.NewSelect(authLabels, nil)
+		authSelect.SetSelectedIndex(0)
+		for i, v := range authValues {
+			if cur.AuthLevel == v {
+				authSelect.SetSelectedIndex(i)
+			}
+		}
+
+		commandEntry := widget.NewEntry()
+		commandEntry.SetPlaceHolder("e.g. xfreerdp /v:{address} /u:{user} +clipboard")
+		commandEntry.SetText(prof.RDPClientCommand)
+
+		template := cur.Template
+		templateLabel := widget.NewLabel("")
+		refreshTemplateLabel := func() {
+			if len(template) == 0 {
+				templateLabel.SetText("None")
+			} else {
+				templateLabel.SetText(fmt.Sprintf("%d imported setting(s)", len(template)))
+			}
+		}
+		refreshTemplateLabel()
+		templateClear := widget.NewButtonWithIcon("", theme.ContentClearIcon(), func() {
+			template = nil
+			refreshTemplateLabel()
+		})
+
+		// readSettings collects the dialog's current values
+		readSettings := func() (RDPSettings, error) {
+			d := RDPSettings{Template: template}
+			var err error
+			d.Width, d.Height, err = ParseResolution(resolutionEntry.Text)
+			if err != nil {
+				return d, err
+			}
+			d.FullScreen = screenSelect.Selected == "Full screen"
+			d.MultiMonitor = screenSelect.Selected == "All monitors"
+			fmt.Sscanf(colorSelect.Selected, "%d", &d.ColorDepth)
+			if d.ColorDepth == 32 {
+				d.ColorDepth = 0
+			}
+			if i := audioSelect.SelectedIndex(); i > 0 {
+				d.Audio = audioValues[i]
+			}
+			d.NoClipboard = !clipboardCheck.Checked
+			d.RedirectDrives = drivesCheck.Checked
+			d.RedirectPrinters = printersCheck.Checked
+			d.NoCompression = !compressionCheck.Checked
+			if i := authSelect.SelectedIndex(); i > 0 {
+				d.AuthLevel = authValues[i]
+			}
+			return d, nil
+		}
+
+		previewBtn := widget.NewButtonWithIcon("Preview .rdp File", theme.VisibilityIcon(), func() {
+			d, err := readSettings()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			spec := LaunchSpec{Address: "localhost:" + localPortEntry.Text, User: userEntry.Text, Title: prof.Name, Display: d}
+			text := widget.NewMultiLineEntry()
+			text.SetText(strings.ReplaceAll(renderRDPFile(spec), "\r\n", "\n"))
+			text.TextStyle = fyne.TextStyle{Monospace: true}
+			text.Wrapping = fyne.TextWrapOff
+			text.SetMinRowsVisible(14)
+			preview := dialog.NewCustom("Generated .rdp File", "Close", text, w)
+			preview.Resize(fyne.NewSize(440, 0))
+			preview.Show()
+		})
+
+		commandItem := widget.NewFormItem("Custom Command", commandEntry)
+		commandItem.HintText = "Used with \"Custom command\". Placeholders: {address} {host} {port} {user} {profile} {width} {height} {rdpfile}"
+
+		items := []*widget.FormItem{
+			widget.NewFormItem("Screen Mode", screenSelect),
+			widget.NewFormItem("Resolution", resolutionEntry),
+			widget.NewFormItem("Colors", colorSelect),
+			widget.NewFormItem("Audio", audioSelect),
+			widget.NewFormItem("Redirect", container.NewHBox(clipboardCheck, drivesCheck, printersCheck)),
+			widget.NewFormItem("Performance", compressionCheck),
+			widget.NewFormItem("Server Authentication", authSelect),
+			widget.NewFormItem("Extra .rdp Settings", container.NewBorder(nil, nil, nil, templateClear, templateLabel)),
+			commandItem,
+			widget.NewFormItem("", previewBtn),
+		}
+		d := dialog.NewForm("RDP Client Settings", "Save", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			settings, err := readSettings()
+			if err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			if _, err := splitCommandLine(commandEntry.Text); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			prof.RDPClientCommand = strings.TrimSpace(commandEntry.Text)
+			prof.Display = settings
+			_ = SaveConfig(cfg)
+			log.Printf("RDP client settings saved for profile %q", prof.Name)
+		}, w)
+		d.Resize(fyne.NewSize(480, 0))
+		d.Show()
+	})
+
+	rdpClientRow := container.NewBorder(nil, nil, nil, rdpClientEdit, rdpClientSelect)
+
+	sshCertRow := container.NewBorder(nil, nil, nil, container.NewHBox(sshCertBrowse, sshCertClear), sshCertLabel)
+
+	var profileSelect *widget.Select
+
+	selectProfile = func(p *Profile) {
+		if p.P12Path != prof.P12Path {
+			p12PassEntry.SetText("")
+		}
+		prof = p
+		cfg.DefaultProfile = p.Name
+		_ = SaveConfig(cfg)
+		showProfile()
+		profileSelect.SetOptions(cfg.ProfileNames())
+		profileSelect.SetSelected(p.Name)
+		if refreshForm != nil {
+			refreshForm()
+		}
+	}
+
+	profileSelect = widget.NewSelect(cfg.ProfileNames(), func(name string) {
+		if name == prof.Name {
+			return
+		}
+		if p := cfg.Profile(name); p != nil {
+			storeProfile()
+			selectProfile(p)
+			log.Printf("Switched to profile %q", name)
+		}
+	})
+	profileSelect.SetSelected(prof.Name)
+
+	// askProfileName prompts for a profile name, then runs apply with it
+	askProfileName := func(title, initial string, apply func(name string) error) {
+		nameEntry := widget.NewEntry()
+		nameEntry.SetText(initial)
+		items := []*widget.FormItem{widget.NewFormItem("Name", nameEntry)}
+		d := dialog.NewForm(title, "OK", "Cancel", items, func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := apply(nameEntry.Text); err != nil {
+				dialog.ShowError(err, w)
+			}
+		}, w)
+		d.Resize(fyne.NewSize(320, 0))
+		d.Show()
+	}
+
+	profileAdd := widget.NewButtonWithIcon("", theme.ContentAddIcon(), func() {
+		askProfileName("New Profile", cfg.UniqueProfileName("New Profile"), func(name string) error {
+			storeProfile()
+			p, err := cfg.AddProfile(name)
+			if err != nil {
+				return err
+			}
+			selectProfile(p)
+			log.Printf("Created profile %q", p.Name)
+			return nil
+		})
+	})
+	profileDuplicate := widget.NewButtonWithIcon("", theme.ContentCopyIcon(), func() {
+		askProfileName("Duplicate Profile", cfg.UniqueProfileName(prof.Name+" (copy)"), func(name string) error {
+			storeProfile()
+			p, err := cfg.DuplicateProfile(prof.Name, name)
+			if err != nil {
+				return err
+			}
+			selectProfile(p)
+			log.Printf("Duplicated profile as %q", p.Name)
+			return nil
+		})
+	})
+	profileRename := widget.NewButtonWithIcon("", theme.DocumentCreateIcon(), func() {
+		oldName := prof.Name
+		askProfileName("Rename Profile", oldName, func(name string) error {
+			if err := cfg.RenameProfile(oldName, name); err != nil {
+				return err
+			}
+			selectProfile(prof)
+			log.Printf("Renamed profile %q to %q", oldName, prof.Name)
+			return nil
+		})
+	})
+	profileDelete := widget.NewButtonWithIcon("", theme.DeleteIcon(), func() {
+		name := prof.Name
+		dialog.ShowConfirm("Delete Profile", fmt.Sprintf("Delete profile %q?", name), func(ok bool) {
+			if !ok {
+				return
+			}
+			if err := cfg.DeleteProfile(name); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			selectProfile(cfg.ActiveProfile())
+			log.Printf("Deleted profile %q", name)
+		}, w)
+	})
+
+	profileRow := container.NewBorder(nil, nil, widget.NewLabel("Profile"),
+		container.NewHBox(profileAdd, profileDuplicate, profileRename, profileDelete), profileSelect)
+
+	showProfile()
+
+	grid := container.NewGridWithColumns(2)
+
+	add := func(label string, input fyne.CanvasObject) {
+		lbl := widget.NewLabel(label)
+		lbl.Alignment = fyne.TextAlignLeading
+		grid.Add(lbl)
+		grid.Add(input)
+	}
+
+	add("Remote Host", container.NewBorder(nil, nil, nil, sshConfigCheck, hostEntry))
+	add("SSH Username", container.NewBorder(nil, nil, nil, userRuleBtn, userEntry))
+	add("Jump Hosts", jumpRow)
+	add("Port Forwards", forwardsRow)
+	add("SOCKS Proxy", socksRow)
+	add("RDP Target", rdpTargetEntry)
+	add("RDP Client", rdpClientRow)
+	add("Local Port", localPortEntry)
+	add("SSH Agent", container.NewHBox(useAgentCheck, forwardAgentCheck))
+	add("Certificate File", p12Row)
+	add("Certificate Password", p12PassEntry)
+	add("SSH Certificate", sshCertRow)
+
+	setInputsEnabled := func(enabled bool) {
+		if enabled {
+			profileSelect.Enable()
+			profileAdd.Enable()
+			profileDuplicate.Enable()
+			profileRename.Enable()
+			profileDelete.Enable()
+			hostEntry.Enable()
+			if prof.UsernameRule == UsernameManual {
+				userEntry.Enable()
+			}
+			userRuleBtn.Enable()
+			sshConfigCheck.Enable()
+			rdpTargetEntry.Enable()
+			localPortEntry.Enable()
+			rdpClientSelect.Enable()
+			rdpClientEdit.Enable()
+			p12PassEntry.Enable()
 			browse.Enable()
+			useAgentCheck.Enable()
+			forwardAgentCheck.Enable()
+			jumpEdit.Enable()
+			forwardsEdit.Enable()
+			socksEdit.Enable()
+			sshCertBrowse.Enable()
+			sshCertClear.Enable()
 		} else {
+			profileSelect.Disable()
+			profileAdd.Disable()
+			profileDuplicate.Disable()
+			profileRename.Disable()
+			profileDelete.Disable()
 			hostEntry.Disable()
 			userEntry.Disable()
+			userRuleBtn.Disable()
+			sshConfigCheck.Disable()
+			rdpTargetEntry.Disable()
 			localPortEntry.Disable()
+			rdpClientSelect.Disable()
+			rdpClientEdit.Disable()
 			p12PassEntry.Disable()
 			browse.Disable()
+			useAgentCheck.Disable()
+			forwardAgentCheck.Disable()
+			jumpEdit.Disable()
+			forwardsEdit.Disable()
+			socksEdit.Disable()
+			sshCertBrowse.Disable()
+			sshCertClear.Disable()
+		}
+	}
+
+	connectOptions := func(p *Profile, user string, signer ssh.Signer) ConnectOptions {
+		return ConnectOptions{
+			Host:         p.RemoteHost,
+			User:         user,
+			JumpHosts:    p.JumpHosts,
+			Signer:       signer,
+			Prompter:     prompter,
+			UseSSHConfig: p.UseSSHConfig,
+			Proxy:        cfg.Proxy,
+			UseAgent:     p.UseAgent,
+			ForwardAgent: p.ForwardAgent,
 		}
 	}
 
@@ -514,16 +2106,32 @@ func main() {
 		testBtn.Disable()
 		log.Print("--- Starting Connection Test ---")
 
-		info, err := validateP12()
+		info, err := validateCredentials()
+		if err != nil {
+			log.Printf("Validation failed: %v", err)
+			testBtn.Enable()
+			return
+		}
+
+		storeProfile()
+		p := prof
+		user, err := sshUser(p, info)
 		if err != nil {
 			log.Printf("Validation failed: %v", err)
 			testBtn.Enable()
 			return
 		}
 
-		updateStatus("Status: Testing SSH connection...")
 		go func() {
-			res, err := TestConnection(hostEntry.Text, userEntry.Text, info)
+			signer, err := loadSigner(p, info)
+			if err != nil {
+				log.Printf("Validation failed: %v", err)
+				fyne.Do(testBtn.Enable)
+				return
+			}
+
+			updateStatus("Status: Testing SSH connection...")
+			res, err := TestConnection(connectOptions(p, user, signer), func(s string) { log.Print(s) })
 
 			fyne.Do(func() {
 				if err != nil {
@@ -539,96 +2147,211 @@ func main() {
 	})
 	testBtn.Importance = widget.HighImportance
 
-	var cancelTunnel context.CancelFunc
+	// refreshForm matches the connect button and inputs to whether the shown profile is connected
+	refreshForm = func() {
+		active := sessions.ByProfile(prof.Name) != nil
+		if active {
+			connectBtn.SetText("Disconnect")
+			connectBtn.Importance = widget.DangerImportance
+		} else if prof.TunnelOnly() {
+			connectBtn.SetText("Open Tunnel")
+			connectBtn.Importance = widget.SuccessImportance
+		} else {
+			connectBtn.SetText("Connect & Launch")
+			connectBtn.Importance = widget.SuccessImportance
+		}
+		connectBtn.Refresh()
+		connectBtn.Enable()
+		setInputsEnabled(!active)
+
+		// Switching profiles stays possible while connected; only the active one is locked
+		profileSelect.Enable()
+		profileAdd.Enable()
+		profileDuplicate.Enable()
+
+		state := "disconnected"
+		for _, sess := range sessions.Sessions() {
+			if strings.HasPrefix(sess.Status(), SessionReconnecting) {
+				state = "reconnecting"
+				break
+			}
+			state = "connected"
+		}
+		updateTray(state)
+	}
+
+	// sessionText describes a session and, once listening, the traffic of each forward
+	sessionText := func(sess *Session) string {
+		text := fmt.Sprintf("%s: %s → %s (%s)", sess.Profile, sess.Endpoint(), sess.Target, sess.Status())
+		if sess.TunnelOnly {
+			text += ", tunnel only"
+		}
+		for _, c := range sess.Forwards() {
+			text += "\n    " + c.Summary()
+		}
+		return text
+	}
+
+	var sessionList *widget.List
+	sessionList = widget.NewList(
+		func() int { return len(sessions.Sessions()) },
+		func() fyne.CanvasObject {
+			label := widget.NewLabel("")
+			label.Truncation = fyne.TextTruncateEllipsis
+			return container.NewBorder(nil, nil, nil, widget.NewButtonWithIcon("", theme.MediaStopIcon(), nil), label)
+		},
+		func(id widget.ListItemID, obj fyne.CanvasObject) {
+			list := sessions.Sessions()
+			if id >= len(list) {
+				return
+			}
+			sess := list[id]
+			row := obj.(*fyne.Container)
+			row.Objects[0].(*widget.Label).SetText(sessionText(sess))
+			row.Objects[1].(*widget.Button).OnTapped = func() {
+				sess.Log("Disconnect requested by user.")
+				sess.Stop()
+			}
+		},
+	)
+
+	// refreshSessionList sizes each row to its number of forwards
+	refreshSessionList := func() {
+		for i, sess := range sessions.Sessions() {
+			sessionList.SetItemHeight(i, widget.NewLabel(sessionText(sess)).MinSize().Height)
+		}
+		sessionList.Refresh()
+	}
+
+	sessions.OnChange = func() {
+		fyne.Do(func() {
+			refreshSessionList()
+			refreshForm()
+		})
+	}
+
+	// Keep the byte counters moving while tunnels are open
+	go func() {
+		for range time.Tick(2 * time.Second) {
+			if sessions.Active() > 0 {
+				fyne.Do(refreshSessionList)
+			}
+		}
+	}()
+
+	sessions.OnEnded = func(sess *Session, err error) {
+		fyne.Do(func() {
+			if err != nil {
+				sess.Log(fmt.Sprintf("Tunnel error: %v", err))
+				updateStatus(fmt.Sprintf("Status: Connection Error (%s)", sess.Profile))
+				w.Show()
+				dialog.ShowError(fmt.Errorf("%s: %w", sess.Profile, err), w)
+			} else {
+				sess.Log("Session ended normally.")
+				updateStatus(StatusTextDisconnected + " (" + sess.Profile + ")")
+			}
+			if copyAddress == sess.Endpoint() {
+				copyAddressBtn.Hide()
+			}
+		})
+	}
 
 	disconnectFunc = func() {
-		if cancelTunnel != nil {
-			log.Print("Disconnect requested by user.")
-			cancelTunnel()
+		if sessions.Active() > 0 {
+			log.Print("Disconnect of all sessions requested by user.")
+			sessions.StopAll()
 		}
 	}
 
 	connectFunc = func() {
-		if isTunnelActive {
-			dialog.ShowConfirm("Disconnect", "Are you sure you want to disconnect?", func(ok bool) {
+		if sess := sessions.ByProfile(prof.Name); sess != nil {
+			dialog.ShowConfirm("Disconnect", fmt.Sprintf("Are you sure you want to disconnect %q?", sess.Profile), func(ok bool) {
 				if ok {
-					disconnectFunc()
+					sess.Log("Disconnect requested by user.")
+					sess.Stop()
 				}
 			}, w)
 			return
 		}
 
-		connectBtn.Disable()
-		testBtn.Disable()
-		setInputsEnabled(false)
 		log.Print("--- Initiating Connection Sequence ---")
 
-		cfg.RemoteHost = hostEntry.Text
-		cfg.RemoteUser = userEntry.Text
-		cfg.LocalPort = localPortEntry.Text
+		storeProfile()
 		_ = SaveConfig(cfg)
 
-		info, err := validateP12()
+		info, err := validateCredentials()
 		if err != nil {
 			log.Printf("Validation failed: %v", err)
 			w.Show()
 			w.RequestFocus()
 			dialog.ShowError(fmt.Errorf("authentication failed: %v", err), w)
-			connectBtn.Enable()
-			testBtn.Enable()
-			setInputsEnabled(true)
 			return
 		}
 
-		updateStatus("Status: Connecting...")
-
-		var ctx context.Context
-		ctx, cancelTunnel = context.WithCancel(context.Background())
-
-		onReady := func() {
-			fyne.Do(func() {
-				isTunnelActive = true
-				updateStatus(fmt.Sprintf(StatusTextConnected, hostEntry.Text))
-				log.Print("Tunnel Ready. RDP Client Launched.")
-
-				updateTray("connected")
-
-				connectBtn.SetText("Disconnect")
-				connectBtn.Importance = widget.DangerImportance
-				connectBtn.Refresh()
-				connectBtn.Enable()
-			})
+		p := prof
+		user, err := sshUser(p, info)
+		if err != nil {
+			log.Printf("Validation failed: %v", err)
+			w.Show()
+			w.RequestFocus()
+			dialog.ShowError(err, w)
+			return
+		}
+		launcher, err := p.Launcher()
+		if err != nil {
+			log.Printf("RDP client unavailable: %v", err)
+			updateStatus("Status: Error - " + err.Error())
+			dialog.ShowError(err, w)
+			return
 		}
 
-		go func() {
-			err := StartTunnel(ctx, hostEntry.Text, userEntry.Text, localPortEntry.Text, info, func(s string) { log.Print(s) }, onReady)
-
-			fyne.Do(func() {
-				isTunnelActive = false
-				cancelTunnel = nil
+		rdpTarget, _ := NormalizeRDPTarget(p.RDPTarget)
+		sess := &Session{Profile: p.Name, Host: p.RemoteHost, Target: rdpTarget, LocalPort: p.LocalPort, TunnelOnly: p.TunnelOnly()}
+		tunnel := TunnelOptions{
+			LocalPort:    p.LocalPort,
+			RemoteTarget: rdpTarget,
+			OnStatus: func(status string) {
+				sess.SetStatus(status)
+				updateStatus(fmt.Sprintf("Status: %s - %s", sess.Profile, status))
+			},
+			Launcher:   launcher,
+			Title:      p.Name,
+			Display:    p.Display,
+			Forwards:   p.Forwards,
+			SOCKS:      p.SOCKS,
+			OnForwards: sess.SetForwards,
+		}
 
-				// Ignore expected exit codes from user-cancelled RDP sessions
-				isCancel := err == context.Canceled || (err != nil && strings.Contains(err.Error(), "exit status 1") && ctx.Err() == context.Canceled)
+		err = sessions.Start(sess, func(s string) { log.Print(s) }, func(ctx context.Context, sess *Session) error {
+			signer, err := loadSigner(p, info)
+			if err != nil {
+				return err
+			}
 
-				if err != nil && !isCancel {
-					log.Printf("Tunnel error: %v", err)
-					updateStatus("Status: Connection Error")
-					dialog.ShowError(err, w)
-				} else {
-					log.Print("Session ended normally.")
-					updateStatus(StatusTextDisconnected)
+			onReady := func() {
+				sess.SetStatus(SessionConnected)
+				if sess.TunnelOnly {
+					sess.Log("Tunnel Ready at " + sess.Endpoint() + ".")
+					updateStatus(fmt.Sprintf(StatusTextTunnelOnly, sess.Endpoint(), rdpTarget, sess.Host))
+					fyne.Do(func() {
+						copyAddress = sess.Endpoint()
+						copyAddressBtn.Show()
+					})
+					return
 				}
-
-				updateTray("disconnected")
-
-				connectBtn.SetText("Connect & Launch")
-				connectBtn.Importance = widget.SuccessImportance
-				connectBtn.Refresh()
-				connectBtn.Enable()
-				testBtn.Enable()
-				setInputsEnabled(true)
-			})
-		}()
+				sess.Log("Tunnel Ready. RDP Client Launched.")
+				updateStatus(fmt.Sprintf(StatusTextConnected, rdpTarget, sess.Host, sess.LocalPort))
+			}
+			opts := connectOptions(p, user, signer)
+			opts.RefreshSigner = func() (ssh.Signer, error) { return loadSigner(p, info) }
+			return StartTunnel(ctx, opts, tunnel, sess.Log, onReady)
+		})
+		if err != nil {
+			log.Printf("Connection refused: %v", err)
+			dialog.ShowError(err, w)
+			return
+		}
+		updateStatus(fmt.Sprintf("Status: Connecting (%s)...", p.Name))
 	}
 
 	connectBtn = widget.NewButton("Connect & Launch", connectFunc)
@@ -649,9 +2372,17 @@ func main() {
 		widget.NewLabel(""),
 	)
 
+	sessionScroll := container.NewVScroll(sessionList)
+	sessionScroll.SetMinSize(fyne.NewSize(0, 130))
+
+	sessionsTitle := widget.NewLabelWithStyle("Active Sessions", fyne.TextAlignLeading, fyne.TextStyle{Bold: true})
+
 	centerContent := container.NewVBox(
+		profileRow,
 		grid,
 		paddedBtnRow,
+		sessionsTitle,
+		sessionScroll,
 	)
 
 	bottomContent := container.NewVBox(
@@ -692,6 +2423,20 @@ func main() {
 		}
 	})
 
-	w.Resize(fyne.NewSize(480, 420))
+	w.Resize(fyne.NewSize(520, 930))
+	// Certificate expiry is checked at startup and then daily for every profile
+	go func() {
+		ticker := time.NewTicker(ExpiryCheckInterval)
+		defer ticker.Stop()
+		for {
+			fyne.Do(func() {
+				if warnings := checkExpiry(); len(warnings) > 0 && sessions.Active() == 0 {
+					updateStatus("Status: Warning - " + warnings[0].Message())
+				}
+			})
+			<-ticker.C
+		}
+	}()
+
 	w.ShowAndRun()
 }
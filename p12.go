@@ -2,19 +2,26 @@ package main
 
 import (
 	"crypto/x509"
+	"encoding/asn1"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+)
 
-	"golang.org/x/crypto/pkcs12"
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// oidUPN is the Microsoft User Principal Name otherName (szOID_NT_PRINCIPAL_NAME)
+	oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
 )
 
 // P12Info holds certificate credentials extracted from PKCS#12 files
 type P12Info struct {
-	PrivateKey  interface{} // *rsa.PrivateKey or *ecdsa.PrivateKey
+	PrivateKey  interface{} // *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
 	Certificate *x509.Certificate
+	Chain       []*x509.Certificate // other certificates in the file, usually the issuing CAs
 	CommonName  string
-	UPN         string // User Principal Name from certificate SANs or CN
+	UPN         string // User Principal Name from the UPN otherName SAN, else guessed from other SANs or CN
 }
 
 // ParseP12 reads and decodes a PKCS#12 (.p12/.pfx) certificate file
@@ -24,26 +31,23 @@ func ParseP12(path string, password string) (*P12Info, error) {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	pKey, cert, err := pkcs12.Decode(data, password)
+	pKey, cert, chain, err := decodePKCS12(data, password)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode p12: %w", err)
 	}
 
-	if pKey == nil {
-		return nil, fmt.Errorf("no private key found in p12")
-	}
-	if cert == nil {
-		return nil, fmt.Errorf("no certificate found in p12")
-	}
-
 	info := &P12Info{
 		PrivateKey:  pKey,
 		Certificate: cert,
+		Chain:       chain,
 		CommonName:  cert.Subject.CommonName,
 	}
 
-	// Extract UPN from certificate SANs (EmailAddresses or DNSNames) or fallback to CN
-	if len(cert.EmailAddresses) > 0 {
+	// Smart card certificates carry the UPN as an otherName SAN. Without a usable one,
+	// guess it from the other SANs (EmailAddresses or DNSNames) or fall back to the CN.
+	if upns, err := ParseUPNs(cert); err == nil && len(upns) > 0 {
+		info.UPN = upns[0]
+	} else if len(cert.EmailAddresses) > 0 {
 		info.UPN = cert.EmailAddresses[0]
 	} else if len(cert.DNSNames) > 0 {
 		info.UPN = cert.DNSNames[0]
@@ -53,3 +57,65 @@ func ParseP12(path string, password string) (*P12Info, error) {
 
 	return info, nil
 }
+
+// otherName is the GeneralName [0] alternative: a type OID and a value wrapped in [0]
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// ParseUPNs returns the UPN otherNames in the certificate's SubjectAltName extension,
+// which crypto/x509 does not expose
+func ParseUPNs(cert *x509.Certificate) ([]string, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+
+		var names asn1.RawValue
+		if rest, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+			return nil, fmt.Errorf("invalid SubjectAltName: %w", err)
+		} else if len(rest) > 0 || names.Class != asn1.ClassUniversal || names.Tag != asn1.TagSequence {
+			return nil, errors.New("invalid SubjectAltName")
+		}
+
+		var upns []string
+		for data := names.Bytes; len(data) > 0; {
+			var name asn1.RawValue
+			var err error
+			if data, err = asn1.Unmarshal(data, &name); err != nil {
+				return nil, fmt.Errorf("invalid SubjectAltName: %w", err)
+			}
+			if name.Class != asn1.ClassContextSpecific || name.Tag != 0 {
+				continue
+			}
+
+			var on otherName
+			if _, err := asn1.UnmarshalWithParams(name.FullBytes, &on, "tag:0"); err != nil {
+				return nil, fmt.Errorf("invalid otherName: %w", err)
+			}
+			if !on.TypeID.Equal(oidUPN) {
+				continue
+			}
+			if on.Value.Class != asn1.ClassContextSpecific || on.Value.Tag != 0 {
+				return nil, errors.New("invalid otherName value")
+			}
+
+			var value asn1.RawValue
+			if _, err := asn1.Unmarshal(on.Value.Bytes, &value); err != nil {
+				return nil, fmt.Errorf("invalid UPN: %w", err)
+			}
+			// UTF8String per Microsoft; some issuers use other string types
+			switch value.Tag {
+			case asn1.TagUTF8String, asn1.TagIA5String, asn1.TagPrintableString:
+				if upn := strings.TrimSpace(string(value.Bytes)); upn != "" {
+					upns = append(upns, upn)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported UPN string type %d", value.Tag)
+			}
+		}
+		return upns, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// A PKCS#12 (RFC 7292) decoder. golang.org/x/crypto/pkcs12 only knows the SHA-1/3DES/RC2
+// files of older Windows and OpenSSL versions; this one also reads the PBES2/AES files
+// with SHA-2 MACs that OpenSSL 3 and current Windows export by default.
+
+var (
+	oidDataContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedDataContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidSafeContentsBag     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 6}
+
+	oidX509CertType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+)
+
+// ErrP12Password is returned when the MAC (or, without one, the decryption) shows
+// the password is wrong
+var ErrP12Password = errors.New("wrong password")
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue `asn1:"tag:0,optional"` // [0] IMPLICIT OCTET STRING, see implicitOctets
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbmac1Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	MessageAuthScheme pkix.AlgorithmIdentifier
+}
+
+// p12Key and p12Cert are decoded bags with the localKeyId that pairs them
+type p12Key struct {
+	key   crypto.PrivateKey
+	keyID []byte
+}
+
+type p12Cert struct {
+	cert  *x509.Certificate
+	keyID []byte
+}
+
+// decodePKCS12 decodes a PFX file. It returns the private key, the certificate for that
+// key, and every other certificate in the file (usually the CA chain).
+func decodePKCS12(data []byte, password string) (crypto.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	der, err := berToDER(data)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not a PKCS#12 file: %w", err)
+	}
+
+	var pfx pfxPdu
+	if rest, err := asn1.Unmarshal(der, &pfx); err != nil {
+		return nil, nil, nil, fmt.Errorf("not a PKCS#12 file: %w", err)
+	} else if len(rest) > 0 {
+		return nil, nil, nil, errors.New("not a PKCS#12 file: trailing data")
+	}
+	if pfx.Version != 3 {
+		return nil, nil, nil, fmt.Errorf("unsupported PKCS#12 version %d", pfx.Version)
+	}
+	if !pfx.AuthSafe.ContentType.Equal(oidDataContentType) {
+		return nil, nil, nil, errors.New("public-key protected PKCS#12 files are not supported")
+	}
+
+	var authSafeData []byte
+	if _, err := asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeData); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid PKCS#12 content: %w", err)
+	}
+
+	if len(pfx.MacData.Mac.Algorithm.Algorithm) > 0 {
+		if err := verifyPKCS12MAC(&pfx.MacData, authSafeData, password); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	// The MAC covers the bytes as written; the structures inside may be BER too
+	if authSafeData, err = berToDER(authSafeData); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid PKCS#12 content: %w", err)
+	}
+	var authSafe []contentInfo
+	if _, err := asn1.Unmarshal(authSafeData, &authSafe); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid PKCS#12 content: %w", err)
+	}
+
+	var keys []p12Key
+	var certs []p12Cert
+	for _, ci := range authSafe {
+		var contents []byte
+		switch {
+		case ci.ContentType.Equal(oidDataContentType):
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &contents); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid PKCS#12 content: %w", err)
+			}
+		case ci.ContentType.Equal(oidEncryptedDataContentType):
+			var ed encryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid PKCS#12 encrypted content: %w", err)
+			}
+			eci := ed.EncryptedContentInfo
+			ciphertext, err := implicitOctets(eci.EncryptedContent)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("invalid PKCS#12 encrypted content: %w", err)
+			}
+			if contents, err = pbDecrypt(eci.ContentEncryptionAlgorithm, password, ciphertext); err != nil {
+				return nil, nil, nil, fmt.Errorf("certificates: %w", err)
+			}
+		default:
+			return nil, nil, nil, fmt.Errorf("unsupported PKCS#12 content type %s", ci.ContentType)
+		}
+
+		if contents, err = berToDER(contents); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid PKCS#12 safe contents: %w", err)
+		}
+		if err := decodeSafeContents(contents, password, &keys, &certs); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return pairKeyAndCert(keys, certs)
+}
+
+// verifyPKCS12MAC checks the integrity MAC, which is also where a wrong password shows up
+func verifyPKCS12MAC(md *macData, content []byte, password string) error {
+	alg := md.Mac.Algorithm
+
+	if alg.Algorithm.Equal(oidPBMAC1) {
+		// RFC 9579: HMAC keyed with PBKDF2 over the UTF-8 password
+		var params pbmac1Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+			return fmt.Errorf("invalid PBMAC1 parameters: %w", err)
+		}
+		h, err := hmacDigestByOID(params.MessageAuthScheme)
+		if err != nil {
+			return err
+		}
+		key, err := pbkdf2Key(params.KeyDerivationFunc, password, h().Size())
+		if err != nil {
+			return err
+		}
+		return checkMAC(h, key, content, md.Mac.Digest)
+	}
+
+	h, v, err := digestByOID(alg.Algorithm)
+	if err != nil {
+		return fmt.Errorf("MAC: %w", err)
+	}
+	if err := checkIterations(md.Iterations); err != nil {
+		return fmt.Errorf("MAC: %w", err)
+	}
+	derive := func(pass []byte) []byte {
+		return pkcs12KDF(h, v, pass, md.MacSalt, md.Iterations, pkcs12MACID, h().Size())
+	}
+
+	err = checkMAC(h, derive(bmpPassword(password)), content, md.Mac.Digest)
+	if err != nil && password == "" {
+		// An empty password is encoded as a bare NUL by some tools and as nothing by others
+		err = checkMAC(h, derive(nil), content, md.Mac.Digest)
+	}
+	return err
+}
+
+func checkMAC(h func() hash.Hash, key, content, want []byte) error {
+	mac := hmac.New(h, key)
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), want) {
+		return fmt.Errorf("%w (MAC verification failed)", ErrP12Password)
+	}
+	return nil
+}
+
+// decodeSafeContents collects the keys and certificates of a SafeContents sequence
+func decodeSafeContents(data []byte, password string, keys *[]p12Key, certs *[]p12Cert) error {
+	var bags []safeBag
+	if _, err := asn1.Unmarshal(data, &bags); err != nil {
+		return fmt.Errorf("invalid PKCS#12 safe contents: %w", err)
+	}
+
+	for _, bag := range bags {
+		keyID := bagLocalKeyID(bag)
+		switch {
+		case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+			var epki encryptedPrivateKeyInfo
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &epki); err != nil {
+				return fmt.Errorf("invalid encrypted private key: %w", err)
+			}
+			pkcs8, err := pbDecrypt(epki.Algorithm, password, epki.EncryptedData)
+			if err != nil {
+				return fmt.Errorf("private key: %w", err)
+			}
+			key, err := x509.ParsePKCS8PrivateKey(pkcs8)
+			if err != nil {
+				return fmt.Errorf("failed to parse private key: %w", err)
+			}
+			*keys = append(*keys, p12Key{key, keyID})
+
+		case bag.ID.Equal(oidKeyBag):
+			key, err := x509.ParsePKCS8PrivateKey(bag.Value.Bytes)
+			if err != nil {
+				return fmt.Errorf("failed to parse private key: %w", err)
+			}
+			*keys = append(*keys, p12Key{key, keyID})
+
+		case bag.ID.Equal(oidCertBag):
+			var cb certBag
+			if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil {
+				return fmt.Errorf("invalid certificate bag: %w", err)
+			}
+			if !cb.ID.Equal(oidX509CertType) {
+				continue // SDSI certificates and the like
+			}
+			cert, err := x509.ParseCertificate(cb.Data)
+			if err != nil {
+				return fmt.Errorf("failed to parse certificate: %w", err)
+			}
+			*certs = append(*certs, p12Cert{cert, keyID})
+
+		case bag.ID.Equal(oidSafeContentsBag):
+			if err := decodeSafeContents(bag.Value.Bytes, password, keys, certs); err != nil {
+				return err
+			}
+		}
+		// CRL and secret bags carry nothing we use
+	}
+	return nil
+}
+
+// bagLocalKeyID returns the localKeyId attribute, which links a key to its certificate
+func bagLocalKeyID(bag safeBag) []byte {
+	for _, attr := range bag.Attributes {
+		if !attr.ID.Equal(oidLocalKeyID) {
+			continue
+		}
+		var id []byte
+		if _, err := asn1.Unmarshal(attr.Value.Bytes, &id); err == nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// pairKeyAndCert picks the private key and its certificate. Files with a CA chain hold
+// several certificates in no particular order: the leaf is the one sharing the key's
+// localKeyId, or failing that, its public key.
+func pairKeyAndCert(keys []p12Key, certs []p12Cert) (crypto.PrivateKey, *x509.Certificate, []*x509.Certificate, error) {
+	if len(keys) == 0 {
+		return nil, nil, nil, errors.New("no private key found in p12")
+	}
+	if len(certs) == 0 {
+		return nil, nil, nil, errors.New("no certificate found in p12")
+	}
+
+	leafFor := func(k p12Key) int {
+		if len(k.keyID) > 0 {
+			for i, c := range certs {
+				if bytes.Equal(c.keyID, k.keyID) && publicKeyMatches(c.cert, k.key) {
+					return i
+				}
+			}
+		}
+		for i, c := range certs {
+			if publicKeyMatches(c.cert, k.key) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	for _, k := range keys {
+		i := leafFor(k)
+		if i < 0 {
+			continue
+		}
+		var others []*x509.Certificate
+		for j, c := range certs {
+			if j != i {
+				others = append(others, c.cert)
+			}
+		}
+		return k.key, certs[i].cert, others, nil
+	}
+	return nil, nil, nil, errors.New("no certificate in p12 matches its private key")
+}
+
+// publicKeyMatches reports whether cert certifies the public half of key
+func publicKeyMatches(cert *x509.Certificate, key crypto.PrivateKey) bool {
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return false
+	}
+	pub, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	return ok && pub.Equal(cert.PublicKey)
+}
+
+// maxBERDepth bounds the nesting berToDER follows. Real files nest about a dozen levels;
+// a crafted one could otherwise exhaust the stack.
+const maxBERDepth = 64
+
+// berToDER rewrites indefinite-length BER, which Java keytool and some Windows
+// versions emit, into the definite-length DER that encoding/asn1 requires
+func berToDER(data []byte) ([]byte, error) {
+	out, rest, err := berElement(data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after PKCS#12 structure")
+	}
+	return out, nil
+}
+
+// berElement converts one element, depth levels down, and returns the input that follows it
+func berElement(data []byte, depth int) ([]byte, []byte, error) {
+	if depth > maxBERDepth {
+		return nil, nil, errors.New("ASN.1 nesting too deep")
+	}
+	if len(data) < 2 {
+		return nil, nil, errors.New("truncated ASN.1 element")
+	}
+
+	// Identifier octets, including high tag numbers
+	hdr := 1
+	if data[0]&0x1f == 0x1f {
+		for hdr < len(data) && data[hdr]&0x80 != 0 {
+			hdr++
+		}
+		hdr++
+	}
+	if hdr >= len(data) {
+		return nil, nil, errors.New("truncated ASN.1 tag")
+	}
+	tag := data[:hdr]
+	constructed := data[0]&0x20 != 0
+
+	lenByte := data[hdr]
+	rest := data[hdr+1:]
+
+	if lenByte == 0x80 {
+		// Indefinite length: constructed contents up to the end-of-contents marker
+		if !constructed {
+			return nil, nil, errors.New("indefinite length on a primitive ASN.1 element")
+		}
+		var body []byte
+		for {
+			if len(rest) < 2 {
+				return nil, nil, errors.New("missing end-of-contents marker")
+			}
+			if rest[0] == 0 && rest[1] == 0 {
+				rest = rest[2:]
+				break
+			}
+			child, next, err := berElement(rest, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			body = append(body, child...)
+			rest = next
+		}
+		out, err := derConstructed(tag, body)
+		return out, rest, err
+	}
+
+	length := int(lenByte)
+	if lenByte&0x80 != 0 {
+		n := int(lenByte & 0x7f)
+		if n > 4 || n > len(rest) {
+			return nil, nil, errors.New("invalid ASN.1 length")
+		}
+		length = 0
+		for _, b := range rest[:n] {
+			length = length<<8 | int(b)
+		}
+		rest = rest[n:]
+	}
+	if length < 0 || length > len(rest) {
+		return nil, nil, errors.New("truncated ASN.1 element")
+	}
+	contents, rest := rest[:length], rest[length:]
+
+	if !constructed {
+		return append(append(append([]byte(nil), tag...), derLength(length)...), contents...), rest, nil
+	}
+
+	// Definite-length constructed elements may still contain indefinite children
+	var body []byte
+	for len(contents) > 0 {
+		child, next, err := berElement(contents, depth+1)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = append(body, child...)
+		contents = next
+	}
+	out, err := derConstructed(tag, body)
+	return out, rest, err
+}
+
+// derConstructed encodes a constructed element from its converted children. A
+// constructed OCTET STRING becomes the primitive concatenation of its segments.
+func derConstructed(tag, body []byte) ([]byte, error) {
+	if len(tag) != 1 || tag[0] != 0x24 {
+		return append(append(append([]byte(nil), tag...), derLength(len(body))...), body...), nil
+	}
+
+	octets, err := octetSegments(body)
+	if err != nil {
+		return nil, err
+	}
+	return append(append([]byte{0x04}, derLength(len(octets))...), octets...), nil
+}
+
+// implicitOctets returns the value of an implicitly tagged OCTET STRING. berToDER
+// cannot tell one from an explicit tag, so a constructed one, as Windows and Java write
+// EncryptedContent, still holds its segments here.
+func implicitOctets(v asn1.RawValue) ([]byte, error) {
+	if !v.IsCompound {
+		return v.Bytes, nil
+	}
+	return octetSegments(v.Bytes)
+}
+
+// octetSegments concatenates the primitive OCTET STRING segments of a constructed one
+func octetSegments(body []byte) ([]byte, error) {
+	var octets []byte
+	for len(body) > 0 {
+		var seg asn1.RawValue
+		rest, err := asn1.Unmarshal(body, &seg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OCTET STRING segment: %w", err)
+		}
+		if seg.Class != asn1.ClassUniversal || seg.Tag != asn1.TagOctetString || seg.IsCompound {
+			return nil, errors.New("invalid OCTET STRING segment")
+		}
+		octets = append(octets, seg.Bytes...)
+		body = rest
+	}
+	return octets, nil
+}
+
+func derLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
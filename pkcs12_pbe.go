@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"math/big"
+	"unicode/utf16"
+)
+
+// Password-based encryption used inside PKCS#12 files: PBES2 (RFC 8018) as written
+// by OpenSSL 3 and current Windows, and the PKCS#12 PBE schemes of older exports
+
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidPBMAC1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 14}
+
+	oidPBEWithSHAAnd128BitRC2CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 5}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd2KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 4}
+
+	oidAES128CBC   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC   = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC  = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	oidHMACWithSHA = asn1.ObjectIdentifier{1, 2, 840, 113549, 2} // .7 SHA-1, .8 SHA-224, .9 SHA-256, .10 SHA-384, .11 SHA-512
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+)
+
+// errDecryption is how a wrong password shows in files without a MAC
+var errDecryption = fmt.Errorf("%w (decryption failed)", ErrP12Password)
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+type pkcs12PBEParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// digestByOID returns the hash for a digest algorithm and its PKCS#12 KDF block size
+func digestByOID(oid asn1.ObjectIdentifier) (func() hash.Hash, int, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return sha1.New, 64, nil
+	case oid.Equal(oidSHA224):
+		return sha256.New224, 64, nil
+	case oid.Equal(oidSHA256):
+		return sha256.New, 64, nil
+	case oid.Equal(oidSHA384):
+		return sha512.New384, 128, nil
+	case oid.Equal(oidSHA512):
+		return sha512.New, 128, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+// hmacDigestByOID maps an hmacWithSHA* PRF to its hash; an absent PRF means SHA-1
+func hmacDigestByOID(alg pkix.AlgorithmIdentifier) (func() hash.Hash, error) {
+	if len(alg.Algorithm) == 0 {
+		return sha1.New, nil
+	}
+	if len(alg.Algorithm) == len(oidHMACWithSHA)+1 && alg.Algorithm[:len(oidHMACWithSHA)].Equal(oidHMACWithSHA) {
+		switch alg.Algorithm[len(oidHMACWithSHA)] {
+		case 7:
+			return sha1.New, nil
+		case 8:
+			return sha256.New224, nil
+		case 9:
+			return sha256.New, nil
+		case 10:
+			return sha512.New384, nil
+		case 11:
+			return sha512.New, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported PBKDF2 PRF %s", alg.Algorithm)
+}
+
+// bmpPassword encodes a password as PKCS#12 expects for its own KDF: UTF-16BE
+// with a terminating NUL
+func bmpPassword(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	out := make([]byte, 0, 2*len(units)+2)
+	for _, u := range units {
+		out = append(out, byte(u>>8), byte(u))
+	}
+	return append(out, 0, 0)
+}
+
+// PKCS#12 KDF purposes (RFC 7292 appendix B.3)
+const (
+	pkcs12KeyID byte = 1
+	pkcs12IVID  byte = 2
+	pkcs12MACID byte = 3
+)
+
+// maxPBEIterations bounds the iteration counts a file can ask for. OpenSSL writes 2048
+// and Windows 2000 or 10000; a crafted count could keep the UI busy for hours.
+const maxPBEIterations = 10_000_000
+
+// checkIterations rejects iteration counts outside 1..maxPBEIterations before any
+// key is derived
+func checkIterations(n int) error {
+	if n < 1 || n > maxPBEIterations {
+		return fmt.Errorf("unsupported iteration count %d", n)
+	}
+	return nil
+}
+
+// pkcs12KDF is the key derivation of RFC 7292 appendix B.2, used for MAC keys and the
+// legacy PBE schemes. v is the hash block size in bytes.
+func pkcs12KDF(h func() hash.Hash, v int, password, salt []byte, iterations int, id byte, size int) []byte {
+	u := h().Size()
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+
+	d := make([]byte, v)
+	for i := range d {
+		d[i] = id
+	}
+	I := append(fill(salt), fill(password)...)
+
+	one := big.NewInt(1)
+	var out []byte
+	for len(out) < size {
+		digest := h()
+		digest.Write(d)
+		digest.Write(I)
+		a := digest.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			digest = h()
+			digest.Write(a)
+			a = digest.Sum(nil)
+		}
+		out = append(out, a...)
+		if len(out) >= size {
+			break
+		}
+
+		// I_j = (I_j + B + 1) mod 2^(8v) for each v-byte block of I
+		b := new(big.Int).SetBytes(fill(a[:u])[:v])
+		b.Add(b, one)
+		for j := 0; j < len(I); j += v {
+			block := new(big.Int).SetBytes(I[j : j+v])
+			block.Add(block, b)
+			sum := block.Bytes()
+			if len(sum) > v {
+				sum = sum[len(sum)-v:]
+			}
+			clear(I[j : j+v])
+			copy(I[j+v-len(sum):j+v], sum)
+		}
+	}
+	return out[:size]
+}
+
+// pbDecrypt decrypts data protected by a password-based encryption algorithm
+func pbDecrypt(alg pkix.AlgorithmIdentifier, password string, data []byte) ([]byte, error) {
+	block, iv, err := pbCipher(alg, password)
+	if err != nil {
+		return nil, err
+	}
+
+	bs := block.BlockSize()
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, fmt.Errorf("encrypted data is not a multiple of the block size")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	// PKCS#7 padding; garbage here almost always means a wrong password
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > bs {
+		return nil, errDecryption
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errDecryption
+		}
+	}
+	return out[:len(out)-pad], nil
+}
+
+// pbCipher derives the block cipher and IV for alg from the password
+func pbCipher(alg pkix.AlgorithmIdentifier, password string) (cipher.Block, []byte, error) {
+	if alg.Algorithm.Equal(oidPBES2) {
+		return pbes2Cipher(alg.Parameters.FullBytes, password)
+	}
+
+	var params pkcs12PBEParams
+	if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &params); err != nil {
+		return nil, nil, fmt.Errorf("invalid PBE parameters: %w", err)
+	}
+	if err := checkIterations(params.Iterations); err != nil {
+		return nil, nil, err
+	}
+	pass := bmpPassword(password)
+	derive := func(id byte, size int) []byte {
+		return pkcs12KDF(sha1.New, 64, pass, params.Salt, params.Iterations, id, size)
+	}
+
+	var block cipher.Block
+	var err error
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd3KeyTripleDESCBC):
+		block, err = des.NewTripleDESCipher(derive(pkcs12KeyID, 24))
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd2KeyTripleDESCBC):
+		key := derive(pkcs12KeyID, 16)
+		block, err = des.NewTripleDESCipher(append(key, key[:8]...))
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd128BitRC2CBC):
+		block, err = newRC2Cipher(derive(pkcs12KeyID, 16), 128)
+	case alg.Algorithm.Equal(oidPBEWithSHAAnd40BitRC2CBC):
+		block, err = newRC2Cipher(derive(pkcs12KeyID, 5), 40)
+	default:
+		return nil, nil, fmt.Errorf("unsupported encryption algorithm %s", alg.Algorithm)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return block, derive(pkcs12IVID, block.BlockSize()), nil
+}
+
+// pbes2Cipher handles PBES2 with PBKDF2 and AES-CBC or 3DES-CBC. Unlike the PKCS#12
+// schemes, the password is used as UTF-8.
+func pbes2Cipher(paramBytes []byte, password string) (cipher.Block, []byte, error) {
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(paramBytes, &params); err != nil {
+		return nil, nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+
+	var keyLen int
+	var newCipher func([]byte) (cipher.Block, error)
+	scheme := params.EncryptionScheme.Algorithm
+	switch {
+	case scheme.Equal(oidAES128CBC):
+		keyLen, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keyLen, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keyLen, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keyLen, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, nil, fmt.Errorf("unsupported PBES2 encryption scheme %s", scheme)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, nil, fmt.Errorf("invalid PBES2 IV: %w", err)
+	}
+
+	key, err := pbkdf2Key(params.KeyDerivationFunc, password, keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	block, err := newCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(iv) != block.BlockSize() {
+		return nil, nil, fmt.Errorf("invalid PBES2 IV length %d", len(iv))
+	}
+	return block, iv, nil
+}
+
+// pbkdf2Key derives a key with the PBKDF2 parameters of a PBES2 or PBMAC1 structure.
+// keyLen is used when the parameters do not state the length.
+func pbkdf2Key(kdf pkix.AlgorithmIdentifier, password string, keyLen int) ([]byte, error) {
+	if !kdf.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation function %s", kdf.Algorithm)
+	}
+	var params pbkdf2Params
+	if _, err := asn1.Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+	}
+	if err := checkIterations(params.IterationCount); err != nil {
+		return nil, err
+	}
+	prf, err := hmacDigestByOID(params.PRF)
+	if err != nil {
+		return nil, err
+	}
+	if params.KeyLength > 0 {
+		keyLen = params.KeyLength
+	}
+	return pbkdf2.Key(prf, password, params.Salt, params.IterationCount, keyLen)
+}
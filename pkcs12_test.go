@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The files in testdata hold a P-256 key for "CN=John Doe" issued by "Test Issuing CA"
+// under "Test Root CA". They were written with OpenSSL 3:
+//
+//	openssl pkcs12 -export -inkey user.key -in user.pem -certfile chain.pem -passout pass:Secr3t! -out aes.p12
+//	openssl pkcs12 -export -legacy -inkey user.key -in user.pem -certfile chain.pem -passout pass:Secr3t! -out legacy.p12
+//	openssl pkcs12 -export -inkey user.key -in user.pem -passout pass: -out empty.p12
+//
+// ber.p12 is legacy.p12 re-encoded the way Java keytool and Windows write it: indefinite
+// lengths throughout, OCTET STRINGs in constructed segments, and the EncryptedContent as a
+// constructed [0], with the MAC recomputed over the new content.
+
+// explicit0 wraps DER in the [0] EXPLICIT tag that bag values and content use
+func explicit0(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// buildPFX assembles an unencrypted PFX without a MAC holding bags
+func buildPFX(t *testing.T, bags ...safeBag) []byte {
+	t.Helper()
+	contents := mustMarshal(t, mustMarshal(t, bags))
+	authSafe := mustMarshal(t, []contentInfo{{ContentType: oidDataContentType, Content: explicit0(contents)}})
+	return mustMarshal(t, pfxPdu{
+		Version:  3,
+		AuthSafe: contentInfo{ContentType: oidDataContentType, Content: explicit0(mustMarshal(t, authSafe))},
+	})
+}
+
+func keyBag(t *testing.T, key *ecdsa.PrivateKey) safeBag {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return safeBag{ID: oidKeyBag, Value: explicit0(der)}
+}
+
+func certBagOf(t *testing.T, cert *x509.Certificate) safeBag {
+	t.Helper()
+	return safeBag{ID: oidCertBag, Value: explicit0(mustMarshal(t, certBag{ID: oidX509CertType, Data: cert.Raw}))}
+}
+
+// newTestCert issues a certificate for a new P-256 key, self-signed when parent is nil
+func newTestCert(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestDecodePKCS12NestedSafeContents(t *testing.T) {
+	cert, key := newTestCert(t, "Nested User", false, nil, nil)
+	nested := safeBag{
+		ID:    oidSafeContentsBag,
+		Value: explicit0(mustMarshal(t, []safeBag{keyBag(t, key), certBagOf(t, cert)})),
+	}
+
+	gotKey, gotCert, chain, err := decodePKCS12(buildPFX(t, nested), "")
+	if err != nil {
+		t.Fatalf("decodePKCS12: %v", err)
+	}
+	if !key.Equal(gotKey) {
+		t.Error("private key from the nested bag does not match")
+	}
+	if !gotCert.Equal(cert) {
+		t.Errorf("certificate = %q, want %q", gotCert.Subject.CommonName, cert.Subject.CommonName)
+	}
+	if len(chain) != 0 {
+		t.Errorf("chain has %d certificates, want none", len(chain))
+	}
+}
+
+func TestDecodePKCS12Files(t *testing.T) {
+	tests := []struct {
+		file     string
+		password string
+		chain    int
+		err      error
+	}{
+		{"aes.p12", "Secr3t!", 2, nil},    // AES-256-CBC, PBKDF2-SHA256, SHA-256 MAC
+		{"legacy.p12", "Secr3t!", 2, nil}, // RC2-40 certificates, 3DES key, SHA-1 MAC
+		{"empty.p12", "", 0, nil},
+		{"ber.p12", "Secr3t!", 2, nil},
+		{"aes.p12", "wrong", 0, ErrP12Password},
+		{"legacy.p12", "", 0, ErrP12Password},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file+"/"+tt.password, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, cert, chain, err := decodePKCS12(data, tt.password)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("err = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decodePKCS12: %v", err)
+			}
+			if !publicKeyMatches(cert, key) {
+				t.Error("certificate does not match the private key")
+			}
+			if cert.Subject.CommonName != "John Doe" {
+				t.Errorf("leaf = %q, want John Doe", cert.Subject.CommonName)
+			}
+			if len(chain) != tt.chain {
+				t.Errorf("chain has %d certificates, want %d", len(chain), tt.chain)
+			}
+		})
+	}
+}
+
+func TestDecodePKCS12ChainOrder(t *testing.T) {
+	root, rootKey := newTestCert(t, "Root", true, nil, nil)
+	issuer, issuerKey := newTestCert(t, "Issuer", true, root, rootKey)
+	leaf, leafKey := newTestCert(t, "Leaf", false, issuer, issuerKey)
+
+	withKeyID := func(bag safeBag, id string) safeBag {
+		value := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: mustMarshal(t, []byte(id))}
+		bag.Attributes = []pkcs12Attribute{{ID: oidLocalKeyID, Value: value}}
+		return bag
+	}
+
+	tests := []struct {
+		name string
+		bags []safeBag
+	}{
+		{"leaf last", []safeBag{certBagOf(t, root), certBagOf(t, issuer), certBagOf(t, leaf), keyBag(t, leafKey)}},
+		{"key first", []safeBag{keyBag(t, leafKey), certBagOf(t, issuer), certBagOf(t, leaf), certBagOf(t, root)}},
+		{"localKeyId", []safeBag{
+			withKeyID(certBagOf(t, issuer), "other"),
+			withKeyID(certBagOf(t, leaf), "1"),
+			withKeyID(keyBag(t, leafKey), "1"),
+			certBagOf(t, root),
+		}},
+		// A localKeyId on the wrong certificate must not win over the public key
+		{"mislabelled", []safeBag{withKeyID(certBagOf(t, issuer), "1"), certBagOf(t, leaf), withKeyID(keyBag(t, leafKey), "1")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, cert, chain, err := decodePKCS12(buildPFX(t, tt.bags...), "")
+			if err != nil {
+				t.Fatalf("decodePKCS12: %v", err)
+			}
+			if !cert.Equal(leaf) {
+				t.Errorf("leaf = %q, want Leaf", cert.Subject.CommonName)
+			}
+			if len(chain) != len(tt.bags)-2 {
+				t.Errorf("chain has %d certificates, want %d", len(chain), len(tt.bags)-2)
+			}
+			for _, c := range chain {
+				if c.Equal(leaf) {
+					t.Error("leaf is also in the chain")
+				}
+			}
+		})
+	}
+}
+
+func TestBERToDER(t *testing.T) {
+	// SEQUENCE { INTEGER 5, constructed OCTET STRING { "ab", "cd" } }, indefinite lengths
+	in := []byte{0x30, 0x80, 0x02, 0x01, 0x05, 0x24, 0x80, 0x04, 0x02, 'a', 'b', 0x04, 0x02, 'c', 'd', 0x00, 0x00, 0x00, 0x00}
+	want := []byte{0x30, 0x09, 0x02, 0x01, 0x05, 0x04, 0x04, 'a', 'b', 'c', 'd'}
+
+	got, err := berToDER(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("berToDER = % x, want % x", got, want)
+	}
+
+	// SEQUENCEs of indefinite length nested n deep
+	nested := func(n int) []byte {
+		return append(bytes.Repeat([]byte{0x30, 0x80}, n), bytes.Repeat([]byte{0x00, 0x00}, n)...)
+	}
+	if _, err := berToDER(nested(maxBERDepth)); err != nil {
+		t.Errorf("berToDER at the nesting limit: %v", err)
+	}
+
+	for _, bad := range [][]byte{
+		nested(maxBERDepth + 2),
+		nested(100000),
+		{0x30, 0x80, 0x02, 0x01, 0x05},       // no end-of-contents
+		{0x04, 0x80, 0x00, 0x00},             // indefinite primitive
+		{0x30, 0x05, 0x02, 0x01},             // truncated
+		{0x24, 0x04, 0x02, 0x02, 0x00, 0x01}, // INTEGER inside an OCTET STRING
+	} {
+		if _, err := berToDER(bad); err == nil {
+			t.Errorf("berToDER(% x) succeeded", bad)
+		}
+	}
+}
+
+func TestImplicitOctets(t *testing.T) {
+	tests := []struct {
+		name string
+		der  []byte
+		want string
+	}{
+		{"primitive", []byte{0x80, 0x02, 'a', 'b'}, "ab"},
+		{"constructed", []byte{0xa0, 0x08, 0x04, 0x02, 'a', 'b', 0x04, 0x02, 'c', 'd'}, "abcd"},
+	}
+	for _, tt := range tests {
+		var v asn1.RawValue
+		if _, err := asn1.Unmarshal(tt.der, &v); err != nil {
+			t.Fatal(err)
+		}
+		got, err := implicitOctets(v)
+		if err != nil || string(got) != tt.want {
+			t.Errorf("%s: implicitOctets = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+// Iteration counts come from the file and must be checked before any key is derived
+func TestPKCS12IterationLimit(t *testing.T) {
+	const tooMany = maxPBEIterations + 1
+	salt := []byte("saltsalt")
+
+	t.Run("MAC", func(t *testing.T) {
+		var pfx pfxPdu
+		if _, err := asn1.Unmarshal(buildPFX(t), &pfx); err != nil {
+			t.Fatal(err)
+		}
+		pfx.MacData = macData{
+			Mac:        digestInfo{Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1}, Digest: make([]byte, 20)},
+			MacSalt:    salt,
+			Iterations: tooMany,
+		}
+		if _, _, _, err := decodePKCS12(mustMarshal(t, pfx), ""); err == nil || !strings.Contains(err.Error(), "iteration count") {
+			t.Errorf("decodePKCS12 = %v, want an iteration count error", err)
+		}
+	})
+
+	t.Run("PBKDF2", func(t *testing.T) {
+		params := mustMarshal(t, pbkdf2Params{Salt: salt, IterationCount: tooMany})
+		kdf := pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: params}}
+		if _, err := pbkdf2Key(kdf, "", 32); err == nil {
+			t.Error("pbkdf2Key accepted the iteration count")
+		}
+	})
+
+	t.Run("PKCS#12 PBE", func(t *testing.T) {
+		params := mustMarshal(t, pkcs12PBEParams{Salt: salt, Iterations: tooMany})
+		alg := pkix.AlgorithmIdentifier{Algorithm: oidPBEWithSHAAnd3KeyTripleDESCBC, Parameters: asn1.RawValue{FullBytes: params}}
+		if _, _, err := pbCipher(alg, ""); err == nil {
+			t.Error("pbCipher accepted the iteration count")
+		}
+	})
+}
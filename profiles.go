@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	DefaultProfileName = "Default"
+	DefaultLocalPort   = "33890"
+)
+
+// Profile is a named set of connection settings
+type Profile struct {
+	Name       string     `json:"name"`
+	RemoteHost string     `json:"remote_host"`
+	RemoteUser string     `json:"remote_user"`
+	LocalPort  string     `json:"local_port"`
+	RDPTarget  string     `json:"rdp_target,omitempty"`
+	JumpHosts  []JumpHost `json:"jump_hosts,omitempty"`
+	Forwards   []Forward  `json:"forwards,omitempty"`
+
+	SOCKS        SOCKSSettings `json:"socks"`
+	P12Path      string        `json:"p12_path"`
+	UserCertPath string        `json:"user_cert_path,omitempty"`
+	CertExpiry   *CertExpiry   `json:"cert_expiry,omitempty"` // recorded when the P12 is loaded
+
+	// UseAgent authenticates with the ssh-agent's keys instead of P12Path
+	UseAgent     bool `json:"use_agent,omitempty"`
+	ForwardAgent bool `json:"forward_agent,omitempty"`
+
+	// UsernameRule derives the username from the P12 certificate instead of RemoteUser
+	// (see DeriveUsername); UsernameRegex is the pattern for UsernameFromRegex
+	UsernameRule  string `json:"username_rule,omitempty"`
+	UsernameRegex string `json:"username_regex,omitempty"`
+
+	// UseSSHConfig treats RemoteHost as a Host alias in ~/.ssh/config, resolved on each connect
+	UseSSHConfig bool `json:"use_ssh_config,omitempty"`
+
+	RDPClient        string      `json:"rdp_client,omitempty"`         // RDPClient* backend; empty auto-detects
+	RDPClientCommand string      `json:"rdp_client_command,omitempty"` // template for RDPClientCustom
+	Display          RDPSettings `json:"display"`
+}
+
+// Launcher returns the RDP client backend selected for the profile
+func (p *Profile) Launcher() (Launcher, error) {
+	return NewLauncher(p.RDPClient, p.RDPClientCommand)
+}
+
+// TunnelOnly reports whether connecting opens the tunnel without launching an RDP client
+func (p *Profile) TunnelOnly() bool {
+	return p.RDPClient == RDPClientNone
+}
+
+func newProfile(name string) *Profile {
+	return &Profile{Name: name, LocalPort: DefaultLocalPort}
+}
+
+// Profile returns the profile with the given name, or nil
+func (c *Config) Profile(name string) *Profile {
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// ActiveProfile returns the default profile, falling back to the first one
+func (c *Config) ActiveProfile() *Profile {
+	if p := c.Profile(c.DefaultProfile); p != nil {
+		return p
+	}
+	if len(c.Profiles) == 0 {
+		c.Profiles = []*Profile{newProfile(DefaultProfileName)}
+	}
+	return c.Profiles[0]
+}
+
+// ProfileNames lists profile names in display order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for _, p := range c.Profiles {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func (c *Config) checkNewProfileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("profile name is required")
+	}
+	if c.Profile(name) != nil {
+		return "", fmt.Errorf("a profile named %q already exists", name)
+	}
+	return name, nil
+}
+
+// ImportProfile adds a profile created elsewhere (e.g. from a .rdp file)
+func (c *Config) ImportProfile(p *Profile) error {
+	name, err := c.checkNewProfileName(p.Name)
+	if err != nil {
+		return err
+	}
+	p.Name = name
+	c.Profiles = append(c.Profiles, p)
+	return nil
+}
+
+// AddProfile creates an empty profile
+func (c *Config) AddProfile(name string) (*Profile, error) {
+	name, err := c.checkNewProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+	p := newProfile(name)
+	c.Profiles = append(c.Profiles, p)
+	return p, nil
+}
+
+// DuplicateProfile copies an existing profile under a new name
+func (c *Config) DuplicateProfile(src, name string) (*Profile, error) {
+	orig := c.Profile(src)
+	if orig == nil {
+		return nil, fmt.Errorf("profile %q not found", src)
+	}
+	name, err := c.checkNewProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p := *orig
+	p.Name = name
+	p.JumpHosts = append([]JumpHost(nil), orig.JumpHosts...)
+	p.Forwards = append([]Forward(nil), orig.Forwards...)
+	p.SOCKS.Rules = append([]string(nil), orig.SOCKS.Rules...)
+	p.Display.Template = append([]string(nil), orig.Display.Template...)
+	if orig.CertExpiry != nil {
+		rec := *orig.CertExpiry
+		p.CertExpiry = &rec
+	}
+	c.Profiles = append(c.Profiles, &p)
+	return &p, nil
+}
+
+// RenameProfile renames a profile, keeping the default pointing at it
+func (c *Config) RenameProfile(oldName, newName string) error {
+	p := c.Profile(oldName)
+	if p == nil {
+		return fmt.Errorf("profile %q not found", oldName)
+	}
+	if strings.TrimSpace(newName) == oldName {
+		return nil
+	}
+	newName, err := c.checkNewProfileName(newName)
+	if err != nil {
+		return err
+	}
+
+	p.Name = newName
+	if c.DefaultProfile == oldName {
+		c.DefaultProfile = newName
+	}
+	return nil
+}
+
+// DeleteProfile removes a profile; the last remaining profile cannot be deleted
+func (c *Config) DeleteProfile(name string) error {
+	if len(c.Profiles) <= 1 {
+		return fmt.Errorf("cannot delete the only profile")
+	}
+	for i, p := range c.Profiles {
+		if p.Name == name {
+			c.Profiles = append(c.Profiles[:i], c.Profiles[i+1:]...)
+			if c.DefaultProfile == name {
+				c.DefaultProfile = c.Profiles[0].Name
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("profile %q not found", name)
+}
+
+// UniqueProfileName returns base, or base with a numeric suffix if it is taken
+func (c *Config) UniqueProfileName(base string) string {
+	name := base
+	for i := 2; c.Profile(name) != nil; i++ {
+		name = fmt.Sprintf("%s %d", base, i)
+	}
+	return name
+}
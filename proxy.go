@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyEnvironment as the proxy setting picks the proxy from HTTPS_PROXY or
+// ALL_PROXY, honoring NO_PROXY
+const ProxyEnvironment = "env"
+
+func init() {
+	// Teach x/net/proxy.FromURL the HTTP CONNECT schemes; socks5 and socks5h are built in
+	proxy.RegisterDialerType("http", newHTTPConnectDialer)
+	proxy.RegisterDialerType("https", newHTTPConnectDialer)
+}
+
+// ParseProxy validates a proxy setting: empty (direct), "env", or a
+// http://, https://, socks5:// or socks5h:// URL with optional user:password.
+// The returned URL is nil for the direct and environment settings.
+func ParseProxy(setting string) (*url.URL, error) {
+	if setting == "" || setting == ProxyEnvironment {
+		return nil, nil
+	}
+	return parseProxyURL(setting)
+}
+
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: expected scheme://[user:password@]host[:port]", raw)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (use http, https, socks5 or socks5h)", u.Scheme)
+	}
+
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultProxyPort(u.Scheme))
+	}
+	return u, nil
+}
+
+func defaultProxyPort(scheme string) string {
+	switch scheme {
+	case "https":
+		return "443"
+	case "socks5", "socks5h":
+		return DefaultSOCKSPort
+	}
+	return "80"
+}
+
+// resolveProxy returns the proxy to use for reaching addr, or nil to dial directly
+func resolveProxy(setting, addr string) (*url.URL, error) {
+	if setting != ProxyEnvironment {
+		return ParseProxy(setting)
+	}
+
+	env := httpproxy.FromEnvironment()
+	raw := env.HTTPSProxy
+	if raw == "" {
+		raw = getenvAny("ALL_PROXY", "all_proxy")
+	}
+	if raw == "" {
+		return nil, nil
+	}
+
+	// httpproxy applies NO_PROXY (and skips loopback targets); the SSH server is
+	// treated like an https:// destination for the lookup
+	lookup := httpproxy.Config{HTTPSProxy: raw, NoProxy: env.NoProxy}
+	u, err := lookup.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return parseProxyURL(u.String())
+}
+
+func getenvAny(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// dialProxy opens the TCP connection to addr that the first SSH hop runs over,
+// through the configured proxy when one applies
+func dialProxy(ctx context.Context, setting, addr string, timeout time.Duration, logFunc func(string)) (net.Conn, error) {
+	base := &net.Dialer{Timeout: timeout}
+
+	u, err := resolveProxy(setting, addr)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		if setting == ProxyEnvironment {
+			logFunc(fmt.Sprintf("No proxy from the environment applies to %s; dialing directly.", addr))
+		}
+		return base.DialContext(ctx, "tcp", addr)
+	}
+
+	logFunc(fmt.Sprintf("Connecting to %s through proxy %s...", addr, u.Redacted()))
+	dialer, err := proxy.FromURL(u, base)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var conn net.Conn
+	if cd, ok := dialer.(proxy.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+	}
+	return conn, nil
+}
+
+// httpConnectDialer tunnels TCP connections through an HTTP(S) proxy with the
+// CONNECT method, sending Basic credentials when the proxy URL carries them
+type httpConnectDialer struct {
+	proxy   *url.URL
+	forward proxy.Dialer
+}
+
+func newHTTPConnectDialer(u *url.URL, forward proxy.Dialer) (proxy.Dialer, error) {
+	return &httpConnectDialer{proxy: u, forward: forward}, nil
+}
+
+// Dial implements proxy.Dialer
+func (d *httpConnectDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+// DialContext implements proxy.ContextDialer
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if cd, ok := d.forward.(proxy.ContextDialer); ok {
+		conn, err = cd.DialContext(ctx, "tcp", d.proxy.Host)
+	} else {
+		conn, err = d.forward.Dial("tcp", d.proxy.Host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if d.proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{"User-Agent": {AppName + "/" + AppVersion}},
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		conn.Close()
+		if resp.StatusCode == http.StatusProxyAuthRequired {
+			return nil, fmt.Errorf("proxy authentication required for CONNECT to %s: %s", addr, resp.Status)
+		}
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The server spoke first and its banner arrived with the CONNECT reply
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads through the bufio.Reader that consumed the CONNECT reply
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
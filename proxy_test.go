@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testBanner = "SSH-2.0-TestServer\r\n"
+
+// startConnectProxy runs an HTTP CONNECT proxy stand-in on a local port. It answers
+// 407 unless the request carries wantAuth (when set), otherwise 200 followed by an SSH
+// banner in the same write, as when a fast server speaks before the client reads.
+func startConnectProxy(t *testing.T, wantAuth string) (string, <-chan *http.Request) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	requests := make(chan *http.Request, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				requests <- req
+				if wantAuth != "" && req.Header.Get("Proxy-Authorization") != wantAuth {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: Basic realm=\"test\"\r\nContent-Length: 0\r\n\r\n")
+					return
+				}
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"+testBanner)
+				io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), requests
+}
+
+// readBanner reads the first line the SSH server sent through conn
+func readBanner(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the banner: %v", err)
+	}
+	return line
+}
+
+func TestDialProxyHTTPConnect(t *testing.T) {
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("jdoe:s3cret"))
+	tests := []struct {
+		name     string
+		user     string // user:password@ in the proxy URL
+		wantAuth string // what the proxy requires
+		err      string
+	}{
+		{"no auth", "", "", ""},
+		{"basic auth", "jdoe:s3cret@", basic, ""},
+		{"missing credentials", "", basic, "proxy authentication required"},
+		{"wrong password", "jdoe:wrong@", basic, "proxy authentication required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, requests := startConnectProxy(t, tt.wantAuth)
+			setting := "http://" + tt.user + addr
+
+			conn, err := dialProxy(context.Background(), setting, "ssh.example.com:22", 5*time.Second, func(string) {})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("dialProxy error = %v, want %q", err, tt.err)
+				}
+				if strings.Contains(err.Error(), "s3cret") || strings.Contains(err.Error(), "wrong") {
+					t.Errorf("error reveals the proxy password: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dialProxy: %v", err)
+			}
+			defer conn.Close()
+
+			req := <-requests
+			if req.Method != http.MethodConnect || req.RequestURI != "ssh.example.com:22" {
+				t.Errorf("proxy got %s %s, want CONNECT ssh.example.com:22", req.Method, req.RequestURI)
+			}
+			if got := req.Header.Get("Proxy-Authorization"); got != tt.wantAuth {
+				t.Errorf("Proxy-Authorization = %q, want %q", got, tt.wantAuth)
+			}
+
+			// The banner came in the same read as the 200 and must not be lost
+			if _, ok := conn.(*bufferedConn); !ok {
+				t.Errorf("conn is %T, want *bufferedConn holding the banner", conn)
+			}
+			if got := readBanner(t, conn); got != testBanner {
+				t.Errorf("banner = %q, want %q", got, testBanner)
+			}
+		})
+	}
+}
+
+func TestResolveProxyEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy", "REQUEST_METHOD"} {
+		t.Setenv(name, "")
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		addr  string
+		proxy string // empty for direct
+	}{
+		{"unset", nil, "ssh.example.com:22", ""},
+		{"https proxy", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128"}, "ssh.example.com:22", "http://proxy.corp:3128"},
+		{"all proxy", map[string]string{"ALL_PROXY": "socks5h://proxy.corp"}, "ssh.example.com:22", "socks5h://proxy.corp:1080"},
+		{"no_proxy host", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128", "NO_PROXY": "ssh.example.com"}, "ssh.example.com:22", ""},
+		{"no_proxy domain", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128", "NO_PROXY": "localhost,.corp.example.com"}, "bastion.corp.example.com:22", ""},
+		{"no_proxy cidr", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128", "NO_PROXY": "10.0.0.0/8"}, "10.1.2.3:22", ""},
+		{"no_proxy other", map[string]string{"HTTPS_PROXY": "http://proxy.corp:3128", "NO_PROXY": ".corp.example.com"}, "ssh.example.com:22", "http://proxy.corp:3128"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			u, err := resolveProxy(ProxyEnvironment, tt.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := ""
+			if u != nil {
+				got = u.String()
+			}
+			if got != tt.proxy {
+				t.Errorf("resolveProxy(%s) = %q, want %q", tt.addr, got, tt.proxy)
+			}
+		})
+	}
+}
+
+// socks5h leaves the name to the proxy, which matters when only it can resolve the SSH host
+func TestDialProxySOCKS5h(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	requested := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server := &SOCKSServer{Username: "jdoe", Password: "s3cret"}
+		_, target, err := server.Handshake(context.Background(), conn, func(_ context.Context, _, addr string) (net.Conn, error) {
+			requested <- addr
+			local, remote := net.Pipe()
+			go io.WriteString(remote, testBanner)
+			return local, nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer target.Close()
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}()
+
+	setting := fmt.Sprintf("socks5h://jdoe:s3cret@%s", l.Addr())
+	conn, err := dialProxy(context.Background(), setting, "ssh.internal:22", 5*time.Second, func(string) {})
+	if err != nil {
+		t.Fatalf("dialProxy: %v", err)
+	}
+	defer conn.Close()
+
+	if got := <-requested; got != "ssh.internal:22" {
+		t.Errorf("proxy was asked for %q, want the unresolved ssh.internal:22", got)
+	}
+	if got := readBanner(t, conn); got != testBanner {
+		t.Errorf("banner = %q, want %q", got, testBanner)
+	}
+}
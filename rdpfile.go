@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Audio playback modes (RDPSettings.Audio)
+const (
+	AudioLocal  = "local" // play on this computer (default)
+	AudioRemote = "remote"
+	AudioOff    = "off"
+)
+
+// Server authentication levels (RDPSettings.AuthLevel). The certificate never matches
+// "localhost", so AuthRequired only works with a CA-issued certificate checked by name.
+const (
+	AuthWarn     = "warn" // warn, then let the user continue (default)
+	AuthNone     = "none" // connect without warning
+	AuthRequired = "require"
+)
+
+// RDPSettings are the per-profile display and redirection options passed to the RDP client.
+// Zero values are the defaults, so options that are on by default are stored negated.
+type RDPSettings struct {
+	FullScreen   bool   `json:"full_screen,omitempty"`
+	Width        int    `json:"width,omitempty"` // 0 leaves the size to the client
+	Height       int    `json:"height,omitempty"`
+	MultiMonitor bool   `json:"multi_monitor,omitempty"`
+	ColorDepth   int    `json:"color_depth,omitempty"` // 15, 16, 24 or 32 (default)
+	Audio        string `json:"audio,omitempty"`       // Audio*; empty is AudioLocal
+
+	NoClipboard      bool `json:"no_clipboard,omitempty"`
+	RedirectDrives   bool `json:"redirect_drives,omitempty"`
+	RedirectPrinters bool `json:"redirect_printers,omitempty"`
+	NoCompression    bool `json:"no_compression,omitempty"`
+
+	AuthLevel string `json:"auth_level,omitempty"` // Auth*; empty is AuthWarn
+
+	// Template holds further "key:type:value" lines, e.g. from an imported .rdp file.
+	// They are appended to the generated file; a template username replaces the SSH user.
+	Template []string `json:"template,omitempty"`
+}
+
+// Bpp returns the color depth in bits per pixel
+func (s RDPSettings) Bpp() int {
+	switch s.ColorDepth {
+	case 15, 16, 24:
+		return s.ColorDepth
+	}
+	return 32
+}
+
+// audioMode maps Audio to the .rdp audiomode value
+func (s RDPSettings) audioMode() int {
+	switch s.Audio {
+	case AudioRemote:
+		return 1
+	case AudioOff:
+		return 2
+	}
+	return 0
+}
+
+// authenticationLevel maps AuthLevel to the .rdp "authentication level" value
+func (s RDPSettings) authenticationLevel() int {
+	switch s.AuthLevel {
+	case AuthNone:
+		return 0
+	case AuthRequired:
+		return 1
+	}
+	return 2
+}
+
+// Resolution formats the window size as "WxH", or "" when unset
+func (s RDPSettings) Resolution() string {
+	if s.Width == 0 || s.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", s.Width, s.Height)
+}
+
+// ParseResolution parses "WxH" (e.g. "1920x1080"); an empty string means the client default
+func ParseResolution(s string) (width, height int, err error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, 0, nil
+	}
+	ws, hs, ok := strings.Cut(strings.ToLower(s), "x")
+	if ok {
+		width, err = strconv.Atoi(strings.TrimSpace(ws))
+	}
+	if ok && err == nil {
+		height, err = strconv.Atoi(strings.TrimSpace(hs))
+	}
+	if !ok || err != nil || width < 200 || height < 200 || width > 8192 || height > 8192 {
+		return 0, 0, fmt.Errorf("invalid resolution %q, expected e.g. 1920x1080", s)
+	}
+	return width, height, nil
+}
+
+// renderRDPFile builds the .rdp file used by mstsc and by custom commands with {rdpfile}
+func renderRDPFile(spec LaunchSpec) string {
+	d := spec.Display
+	var b strings.Builder
+	line := func(key, typ string, value any) {
+		fmt.Fprintf(&b, "%s:%s:%v\r\n", key, typ, value)
+	}
+
+	screenMode := 1
+	if d.FullScreen || d.MultiMonitor {
+		screenMode = 2
+	}
+	line("screen mode id", "i", screenMode)
+	line("use multimon", "i", boolInt(d.MultiMonitor))
+	if d.Width > 0 && d.Height > 0 {
+		line("desktopwidth", "i", d.Width)
+		line("desktopheight", "i", d.Height)
+	}
+	line("session bpp", "i", d.Bpp())
+
+	var extra []RDPLine
+	username := spec.User
+	for _, raw := range d.Template {
+		l, ok := ParseRDPLine(raw)
+		switch {
+		case !ok || rdpManagedKeys[l.Key]:
+		case l.Key == "username":
+			username = l.Value
+		default:
+			extra = append(extra, l)
+		}
+	}
+
+	line("full address", "s", rdpString(spec.Address))
+	line("username", "s", rdpString(username))
+	line("prompt for credentials", "i", 0)
+	line("authentication level", "i", d.authenticationLevel())
+
+	// The SSH tunnel replaces any RD Gateway
+	line("gatewayusagemethod", "i", 0)
+	line("gatewayprofileusagemethod", "i", 1)
+
+	line("audiomode", "i", d.audioMode())
+	line("redirectclipboard", "i", boolInt(!d.NoClipboard))
+	line("redirectprinters", "i", boolInt(d.RedirectPrinters))
+	if d.RedirectDrives {
+		line("drivestoredirect", "s", "*")
+	} else {
+		line("drivestoredirect", "s", "")
+	}
+	line("compression", "i", boolInt(!d.NoCompression))
+	line("autoreconnection enabled", "i", 1)
+
+	for _, l := range extra {
+		line(l.Key, l.Type, rdpString(l.Value))
+	}
+	return b.String()
+}
+
+// rdpString makes a value safe for a .rdp "s" field: line breaks and other control
+// characters would start a new setting, so they are replaced with spaces
+func rdpString(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// encodeRDPFile converts .rdp content to UTF-16LE with a byte order mark, the encoding
+// mstsc writes; without the BOM it reads the file in the ANSI code page
+func encodeRDPFile(content string) []byte {
+	units := utf16.Encode([]rune(content))
+	data := make([]byte, 2, 2+2*len(units))
+	data[0], data[1] = 0xFF, 0xFE
+	for _, u := range units {
+		data = binary.LittleEndian.AppendUint16(data, u)
+	}
+	return data
+}
+
+// writeRDPFile writes the .rdp file for spec to a temp file and returns its path
+func writeRDPFile(spec LaunchSpec) (string, error) {
+	return writeTempFile("*.rdp", encodeRDPFile(renderRDPFile(spec)))
+}
+
+// writeTempFile writes content to a new temp file named after pattern and returns its path
+func writeTempFile(pattern string, content []byte) (string, error) {
+	f, err := os.CreateTemp("", strings.ToLower(AppName)+"-"+pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write %s: %w", f.Name(), err)
+	}
+	return f.Name(), nil
+}
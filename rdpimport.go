@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// RDPLine is one "key:type:value" setting of a .rdp file
+type RDPLine struct {
+	Key   string
+	Type  string // "s" string, "i" integer, "b" binary
+	Value string
+}
+
+func (l RDPLine) String() string {
+	return l.Key + ":" + l.Type + ":" + l.Value
+}
+
+// ParseRDPLine splits a "key:type:value" line; the value may itself contain colons
+func ParseRDPLine(line string) (RDPLine, bool) {
+	parts := strings.SplitN(line, ":", 3)
+	if len(parts) != 3 || strings.TrimSpace(parts[0]) == "" {
+		return RDPLine{}, false
+	}
+	l := RDPLine{Key: strings.ToLower(strings.TrimSpace(parts[0])), Type: strings.ToLower(parts[1]), Value: parts[2]}
+	if l.Type != "s" && l.Type != "i" && l.Type != "b" {
+		return RDPLine{}, false
+	}
+	return l, true
+}
+
+// decodeRDPFile converts .rdp bytes to text. mstsc saves UTF-16LE with a BOM; hand-written
+// files are usually UTF-8 or the ANSI code page (read as Latin-1).
+func decodeRDPFile(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order := binary.ByteOrder(binary.LittleEndian)
+		if data[0] == 0xFE {
+			order = binary.BigEndian
+		}
+		units := make([]uint16, 0, len(data)/2)
+		for i := 2; i+1 < len(data); i += 2 {
+			units = append(units, order.Uint16(data[i:]))
+		}
+		return string(utf16.Decode(units))
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:])
+	case utf8.Valid(data):
+		return string(data)
+	}
+
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+// rdpManagedKeys are generated from the profile and its RDPSettings on every launch
+var rdpManagedKeys = map[string]bool{
+	"screen mode id":            true,
+	"use multimon":              true,
+	"desktopwidth":              true,
+	"desktopheight":             true,
+	"session bpp":               true,
+	"full address":              true,
+	"prompt for credentials":    true,
+	"authentication level":      true,
+	"gatewayusagemethod":        true,
+	"gatewayprofileusagemethod": true,
+	"audiomode":                 true,
+	"redirectclipboard":         true,
+	"redirectprinters":          true,
+	"drivestoredirect":          true,
+	"compression":               true,
+	"autoreconnection enabled":  true,
+}
+
+// rdpDroppedKeys cannot work through the tunnel or on another machine, with the reason
+var rdpDroppedKeys = map[string]string{
+	"alternate full address":   "the tunnel endpoint replaces the server address",
+	"gatewayhostname":          "RD Gateway is replaced by the SSH tunnel",
+	"gatewaycredentialssource": "RD Gateway is replaced by the SSH tunnel",
+	"gatewayaccesstoken":       "RD Gateway is replaced by the SSH tunnel",
+	"gatewaybrokeringtype":     "RD Gateway is replaced by the SSH tunnel",
+	"promptcredentialonce":     "RD Gateway is replaced by the SSH tunnel",
+	"password 51":              "saved passwords are encrypted for the original user and machine",
+	"signature":                "the signature does not cover the regenerated file",
+	"signscope":                "the signature does not cover the regenerated file",
+	"kdcproxyname":             "KDC proxy traffic does not go through the tunnel",
+	"gatewayusagemethod":       "RD Gateway is replaced by the SSH tunnel",
+}
+
+// RDPImport is the result of importing a .rdp file
+type RDPImport struct {
+	Profile *Profile
+	Mapped  []string          // settings turned into profile fields
+	Kept    []string          // settings copied into the .rdp template unchanged
+	Dropped map[string]string // settings that could not be carried over, with the reason
+}
+
+// ImportRDPFile creates a profile from a .rdp file: the server address becomes the RDP
+// target, known display and redirection settings become RDPSettings, and everything else
+// is kept as a template for the generated file. The profile is not added to cfg.
+func ImportRDPFile(cfg *Config, path, name string) (*RDPImport, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if strings.TrimSpace(name) == "" {
+		name = cfg.UniqueProfileName(strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)))
+	}
+	name, err = cfg.checkNewProfileName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	imp := &RDPImport{Profile: newProfile(name), Dropped: make(map[string]string)}
+	d := &imp.Profile.Display
+	settings := make(map[string]RDPLine)
+
+	for i, raw := range strings.Split(decodeRDPFile(data), "\n") {
+		raw = strings.TrimRight(raw, "\r\x00")
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+		l, ok := ParseRDPLine(raw)
+		if !ok {
+			imp.Dropped[fmt.Sprintf("line %d", i+1)] = fmt.Sprintf("not a key:type:value setting: %q", raw)
+			continue
+		}
+		settings[l.Key] = l
+	}
+
+	address, ok := settings["full address"]
+	if !ok || strings.TrimSpace(address.Value) == "" {
+		return nil, fmt.Errorf("%s has no \"full address\" setting", filepath.Base(path))
+	}
+	target := strings.TrimSpace(address.Value)
+	imp.Mapped = append(imp.Mapped, "full address")
+	if port, ok := settings["server port"]; ok {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(strings.Trim(target, "[]"), strings.TrimSpace(port.Value))
+			imp.Mapped = append(imp.Mapped, "server port")
+		} else {
+			imp.Dropped["server port"] = "the full address already has a port"
+		}
+	}
+	if target, err = NormalizeRDPTarget(target); err != nil {
+		return nil, err
+	}
+	imp.Profile.RDPTarget = target
+
+	intValue := func(key string) (int, bool) {
+		l, ok := settings[key]
+		if !ok || l.Type != "i" {
+			return 0, false
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(l.Value))
+		return n, err == nil
+	}
+	mapInt := func(key string, apply func(n int)) {
+		if n, ok := intValue(key); ok {
+			apply(n)
+			imp.Mapped = append(imp.Mapped, key)
+		}
+	}
+
+	mapInt("screen mode id", func(n int) { d.FullScreen = n == 2 })
+	mapInt("use multimon", func(n int) { d.MultiMonitor = n == 1 })
+	mapInt("session bpp", func(n int) {
+		if n == 15 || n == 16 || n == 24 {
+			d.ColorDepth = n
+		}
+	})
+	mapInt("audiomode", func(n int) { d.Audio = []string{AudioLocal, AudioRemote, AudioOff}[min(max(n, 0), 2)] })
+	mapInt("redirectclipboard", func(n int) { d.NoClipboard = n == 0 })
+	mapInt("redirectprinters", func(n int) { d.RedirectPrinters = n == 1 })
+	mapInt("compression", func(n int) { d.NoCompression = n == 0 })
+	mapInt("authentication level", func(n int) { d.AuthLevel = []string{AuthNone, AuthRequired, AuthWarn}[min(max(n, 0), 2)] })
+	if w, ok := intValue("desktopwidth"); ok {
+		if h, ok := intValue("desktopheight"); ok {
+			if _, _, err := ParseResolution(fmt.Sprintf("%dx%d", w, h)); err == nil {
+				d.Width, d.Height = w, h
+				imp.Mapped = append(imp.Mapped, "desktopwidth", "desktopheight")
+			}
+		}
+	}
+	if l, ok := settings["drivestoredirect"]; ok {
+		d.RedirectDrives = strings.TrimSpace(l.Value) != ""
+		imp.Mapped = append(imp.Mapped, "drivestoredirect")
+	}
+
+	// Managed settings are only lost if the generated file would say something different
+	generated := make(map[string]string)
+	for _, line := range strings.Split(renderRDPFile(LaunchSpec{Address: target, Display: *d}), "\r\n") {
+		if l, ok := ParseRDPLine(line); ok {
+			generated[l.Key] = l.Value
+		}
+	}
+
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		l := settings[key]
+		reason := rdpDroppedKeys[key]
+		switch {
+		case key == "full address" || key == "server port":
+		case rdpManagedKeys[key]:
+			want, ok := generated[key]
+			if ok && want == strings.TrimSpace(l.Value) {
+				continue
+			}
+			if reason == "" {
+				reason = fmt.Sprintf("value %q is not supported; %s", l.Value, describeGenerated(want, ok))
+			}
+			imp.Dropped[key] = reason
+		case reason != "":
+			imp.Dropped[key] = reason
+		default:
+			d.Template = append(d.Template, l.String())
+			imp.Kept = append(imp.Kept, key)
+		}
+	}
+	return imp, nil
+}
+
+func describeGenerated(value string, ok bool) string {
+	if !ok {
+		return "the client default is used"
+	}
+	return fmt.Sprintf("%q is used", value)
+}
+
+// Summary returns log lines describing the import
+func (imp *RDPImport) Summary() []string {
+	lines := []string{
+		fmt.Sprintf("Imported profile %q: RDP target %s", imp.Profile.Name, imp.Profile.RDPTarget),
+		fmt.Sprintf("  Mapped to profile settings: %s", listOrNone(imp.Mapped)),
+		fmt.Sprintf("  Kept as .rdp template: %s", listOrNone(imp.Kept)),
+	}
+	for _, dropped := range imp.DroppedSettings() {
+		lines = append(lines, "  Not carried over: "+dropped)
+	}
+	return lines
+}
+
+// DroppedSettings lists the settings that could not be carried over as "key (reason)"
+func (imp *RDPImport) DroppedSettings() []string {
+	var list []string
+	for key, reason := range imp.Dropped {
+		list = append(list, fmt.Sprintf("%s (%s)", key, reason))
+	}
+	sort.Strings(list)
+	return list
+}
+
+func listOrNone(items []string) string {
+	if len(items) == 0 {
+		return "none"
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	keepAliveInterval = 30 * time.Second
+	keepAliveTimeout  = 15 * time.Second
+
+	reconnectBaseDelay = 1 * time.Second
+	reconnectMaxDelay  = 60 * time.Second
+
+	// reconnectWait bounds how long a new forward waits for a reconnect in progress
+	reconnectWait = 30 * time.Second
+)
+
+// reconnectBackoff returns the delay before reconnect attempt n (1-based):
+// exponential from reconnectBaseDelay, capped at reconnectMaxDelay, with ±25% jitter
+func reconnectBackoff(attempt int) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 16 {
+		delay = min(reconnectBaseDelay<<(attempt-1), reconnectMaxDelay)
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay)/2)) - delay/4
+	return delay + jitter
+}
+
+// supervisedClient keeps the SSH connection of a tunnel alive. When the transport drops
+// it re-dials with backoff and swaps in the new client, so the local listener stays up
+// and new forwards use whichever client is current.
+type supervisedClient struct {
+	opts     ConnectOptions
+	logFunc  func(string)
+	onStatus func(string)
+
+	mu       sync.Mutex
+	client   *ssh.Client
+	ready    chan struct{} // closed while client is usable
+	replaced chan struct{} // closed when client is swapped for a new one
+	closed   bool          // set by Close, after which nothing is re-dialed
+
+	cancel context.CancelFunc // stops the supervisor
+	failed chan error         // receives an error if reconnecting is abandoned
+}
+
+func newSupervisedClient(ctx context.Context, opts ConnectOptions, logFunc func(string), onStatus func(string)) (*supervisedClient, error) {
+	client, err := dialSSH(ctx, opts, logFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if onStatus == nil {
+		onStatus = func(string) {}
+	}
+
+	s := &supervisedClient{
+		opts:     opts,
+		logFunc:  logFunc,
+		onStatus: onStatus,
+		client:   client,
+		ready:    make(chan struct{}),
+		replaced: make(chan struct{}),
+		failed:   make(chan error, 1),
+	}
+	close(s.ready)
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	go s.supervise(ctx)
+	return s, nil
+}
+
+// current returns the live client, waiting for a reconnect in progress
+func (s *supervisedClient) current(ctx context.Context) (*ssh.Client, error) {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+	default:
+		timer := time.NewTimer(reconnectWait)
+		defer timer.Stop()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, fmt.Errorf("SSH connection is down, reconnect in progress")
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client, nil
+}
+
+// Dial opens a connection from the SSH server to addr over the current client
+func (s *supervisedClient) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, err := s.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Dial(network, addr)
+}
+
+// Listen asks the SSH server to listen on addr over the current client. The returned
+// channel is closed once that client has been replaced after a reconnect, when the
+// listener is gone and has to be requested again. It is returned even with an error, so
+// a listen that failed during a reconnect is retried once the reconnect completes.
+func (s *supervisedClient) Listen(ctx context.Context, addr string) (net.Listener, <-chan struct{}, error) {
+	s.mu.Lock()
+	replaced := s.replaced
+	s.mu.Unlock()
+	if _, err := s.current(ctx); err != nil {
+		return nil, replaced, err
+	}
+
+	s.mu.Lock()
+	client, replaced := s.client, s.replaced
+	s.mu.Unlock()
+
+	l, err := client.Listen("tcp", addr)
+	return l, replaced, err
+}
+
+// Close stops the supervisor and shuts down the current client. The supervisor is
+// stopped first, so the closing connection is not taken for a dropped one.
+func (s *supervisedClient) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	client := s.client
+	s.mu.Unlock()
+
+	s.cancel()
+	return client.Close()
+}
+
+func (s *supervisedClient) supervise(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		client := s.client
+		s.mu.Unlock()
+
+		err := waitForDrop(ctx, client, s.opts.KeepAliveInterval)
+		if ctx.Err() != nil || s.isClosed() {
+			return
+		}
+
+		s.logFunc(fmt.Sprintf("SSH connection lost: %v", err))
+		client.Close()
+
+		s.mu.Lock()
+		s.ready = make(chan struct{})
+		s.mu.Unlock()
+
+		newClient, err := s.reconnect(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				s.failed <- err
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			newClient.Close()
+			return
+		}
+		s.client = newClient
+		close(s.ready)
+		close(s.replaced)
+		s.replaced = make(chan struct{})
+		s.mu.Unlock()
+
+		s.logFunc("SSH connection re-established.")
+		s.onStatus(SessionConnected)
+	}
+}
+
+func (s *supervisedClient) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// reconnect re-dials until it succeeds, the context ends, or the host key no longer matches
+func (s *supervisedClient) reconnect(ctx context.Context) (*ssh.Client, error) {
+	for attempt := 1; ; attempt++ {
+		delay := reconnectBackoff(attempt)
+		s.onStatus(fmt.Sprintf("%s (attempt %d)", SessionReconnecting, attempt))
+		s.logFunc(fmt.Sprintf("Reconnecting in %s (attempt %d)...", delay.Round(100*time.Millisecond), attempt))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+
+		opts := s.opts
+		if opts.RefreshSigner != nil {
+			signer, err := opts.RefreshSigner()
+			if err != nil {
+				s.logFunc(fmt.Sprintf("Reconnect attempt %d failed: %v", attempt, err))
+				continue
+			}
+			opts.Signer = signer
+		}
+
+		client, err := dialSSH(ctx, opts, s.logFunc)
+		if err == nil {
+			return client, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		var changed *HostKeyChangedError
+		if errors.As(err, &changed) {
+			return nil, err
+		}
+		s.logFunc(fmt.Sprintf("Reconnect attempt %d failed: %v", attempt, err))
+	}
+}
+
+// waitForDrop sends keep-alives every interval (keepAliveInterval if zero) until the
+// connection fails (returning why) or ctx ends
+func waitForDrop(ctx context.Context, client *ssh.Client, interval time.Duration) error {
+	closed := make(chan error, 1)
+	go func() {
+		closed <- client.Wait()
+	}()
+
+	if interval <= 0 {
+		interval = keepAliveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-closed:
+			if err == nil {
+				err = errors.New("connection closed by server")
+			}
+			return err
+		case <-ticker.C:
+			if err := keepAlive(client); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// keepAlive sends one keepalive@openssh.com request, failing if no reply arrives in time
+func keepAlive(client *ssh.Client) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return fmt.Errorf("keep-alive failed: %w", err)
+		}
+		return nil
+	case <-time.After(keepAliveTimeout):
+		return fmt.Errorf("keep-alive timed out after %s", keepAliveTimeout)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// acceptPrompter trusts every host key and answers no prompts
+type acceptPrompter struct{}
+
+func (acceptPrompter) ConfirmHostKey(string, net.Addr, ssh.PublicKey) bool { return true }
+func (acceptPrompter) Secret(string, string) (string, bool)                { return "", false }
+func (acceptPrompter) Challenge(string, string, []string, []bool) ([]string, bool) {
+	return nil, false
+}
+
+// testConfigDir points the config directory, and so known_hosts, at a temporary one
+func testConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("APPDATA", dir)
+	t.Setenv("HOME", dir)
+}
+
+// testSSHServer accepts any public key on a local port until the test ends and
+// returns its address and an SSH signer to log in with. It refuses every channel.
+func testSSHServer(t *testing.T) (string, ssh.Signer) {
+	return startSSHServer(t, func(ch ssh.NewChannel) { ch.Reject(ssh.Prohibited, "test server") })
+}
+
+// startSSHServer is testSSHServer with handle called for each channel opened
+func startSSHServer(t *testing.T, handle func(ssh.NewChannel)) (string, ssh.Signer) {
+	t.Helper()
+	newSigner := func() ssh.Signer {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signer, err := ssh.NewSignerFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil },
+	}
+	config.AddHostKey(newSigner())
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					handle(ch)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), newSigner()
+}
+
+// testLog collects log lines and statuses from several goroutines
+type testLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLog) add(s string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, s)
+}
+
+func (l *testLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.lines, "\n")
+}
+
+// Closing the client at the end of a session must not look like a dropped connection
+func TestSupervisedClientClose(t *testing.T) {
+	testConfigDir(t)
+	addr, signer := testSSHServer(t)
+
+	var logs, statuses testLog
+	opts := ConnectOptions{Host: addr, User: "jdoe", Signer: signer, Prompter: acceptPrompter{}}
+	s, err := newSupervisedClient(context.Background(), opts, logs.add, statuses.add)
+	if err != nil {
+		t.Fatalf("newSupervisedClient: %v", err)
+	}
+	s.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if strings.Contains(logs.String(), "connection lost") {
+		t.Errorf("Close was logged as a dropped connection:\n%s", logs.String())
+	}
+	if got := statuses.String(); got != "" {
+		t.Errorf("statuses after Close = %q, want none", got)
+	}
+}
+
+// A server that accepts TCP but never answers the SSH handshake, or a jump host that
+// never answers the channel open for the next hop, must not hold up a cancelled dial
+func TestDialSSHHonorsContext(t *testing.T) {
+	testConfigDir(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	silent := l.Addr().String()
+	jump, signer := startSSHServer(t, func(ssh.NewChannel) {})
+	jumpHost, jumpPort, _ := net.SplitHostPort(jump)
+
+	tests := []struct {
+		name string
+		opts ConnectOptions
+	}{
+		{"handshake", ConnectOptions{Host: silent, User: "jdoe", Prompter: acceptPrompter{}}},
+		{"jump host", ConnectOptions{Host: "10.0.0.1:22", User: "jdoe", Signer: signer, Prompter: acceptPrompter{}, JumpHosts: []JumpHost{{Host: jumpHost, Port: jumpPort}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				client, err := dialSSH(ctx, tt.opts, func(string) {})
+				if client != nil {
+					client.Close()
+				}
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("dialSSH = %v, want %v", err, context.DeadlineExceeded)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("dialSSH ignored the cancelled context")
+			}
+		})
+	}
+}
+
+// A -R forward that asks while a reconnect is in progress must still learn when the
+// new client is up, or it is never requested again
+func TestListenDuringReconnect(t *testing.T) {
+	s := &supervisedClient{ready: make(chan struct{}), replaced: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, replaced, err := s.Listen(ctx, "127.0.0.1:0")
+	if err == nil {
+		t.Fatal("Listen succeeded without a client")
+	}
+	if replaced == nil {
+		t.Fatal("Listen returned no replaced channel with its error")
+	}
+
+	// What supervise does once the reconnect completes
+	s.mu.Lock()
+	close(s.ready)
+	close(s.replaced)
+	s.replaced = make(chan struct{})
+	s.mu.Unlock()
+
+	select {
+	case <-replaced:
+	case <-time.After(time.Second):
+		t.Fatal("replaced channel was not closed by the reconnect")
+	}
+}
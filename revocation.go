@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// DefaultRevocationGrace is how long past its nextUpdate a cached answer is still
+	// accepted while the OCSP responder and CRL servers cannot be reached
+	DefaultRevocationGrace = 72 * time.Hour
+
+	// revocationFetchTimeout bounds each OCSP or CRL request
+	revocationFetchTimeout = 10 * time.Second
+	// revocationMaxSize bounds downloaded CRLs and OCSP responses
+	revocationMaxSize = 16 << 20
+	// revocationClockSkew tolerates responders whose thisUpdate is slightly ahead of us
+	revocationClockSkew = 5 * time.Minute
+	// revocationDefaultLifetime applies to responses without a nextUpdate
+	revocationDefaultLifetime = 24 * time.Hour
+)
+
+// RevocationSettings control the optional CRL/OCSP check of the P12 certificate
+type RevocationSettings struct {
+	Enabled bool   `json:"enabled"`
+	Grace   string `json:"grace,omitempty"` // offline grace period; empty means DefaultRevocationGrace
+
+	// RequireStatus refuses to connect when no responder could vouch for the
+	// certificate, instead of only warning
+	RequireStatus bool `json:"require_status,omitempty"`
+}
+
+// ParseRevocationGrace parses the offline grace period, e.g. "72h"
+func ParseRevocationGrace(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return DefaultRevocationGrace, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid grace period %q: %w", s, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("grace period cannot be negative")
+	}
+	return d, nil
+}
+
+// Revocation states
+const (
+	RevocationGood    = "good"
+	RevocationRevoked = "revoked"
+	RevocationUnknown = "unknown"
+)
+
+// RevocationStatus is the outcome of a revocation check
+type RevocationStatus struct {
+	State      string
+	Source     string // "OCSP <url>" or "CRL <url>" that answered
+	Cached     bool   // answered from the cache without contacting the source
+	Offline    bool   // the source was unreachable; a cached answer within the grace period was used
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	Reason     int   // RFC 5280 CRLReason when revoked
+	Err        error // why the state is unknown
+}
+
+// String is a one-line summary for the activity log and certificate details
+func (s *RevocationStatus) String() string {
+	switch s.State {
+	case RevocationRevoked:
+		return fmt.Sprintf("REVOKED on %s (%s, per %s)", s.RevokedAt.Local().Format(time.DateTime), revocationReason(s.Reason), s.Source)
+	case RevocationGood:
+		msg := "good per " + s.Source
+		switch {
+		case s.Offline:
+			msg += fmt.Sprintf(" (offline: cached answer expired %s)", s.NextUpdate.Local().Format(time.DateTime))
+		case s.Cached:
+			msg += fmt.Sprintf(" (cached until %s)", s.NextUpdate.Local().Format(time.DateTime))
+		}
+		return msg
+	}
+	return fmt.Sprintf("unknown: %v", s.Err)
+}
+
+var revocationReasons = map[int]string{
+	ocsp.Unspecified:          "unspecified",
+	ocsp.KeyCompromise:        "key compromise",
+	ocsp.CACompromise:         "CA compromise",
+	ocsp.AffiliationChanged:   "affiliation changed",
+	ocsp.Superseded:           "superseded",
+	ocsp.CessationOfOperation: "cessation of operation",
+	ocsp.CertificateHold:      "certificate hold",
+	ocsp.RemoveFromCRL:        "remove from CRL",
+	ocsp.PrivilegeWithdrawn:   "privilege withdrawn",
+	ocsp.AACompromise:         "AA compromise",
+}
+
+func revocationReason(code int) string {
+	if name, ok := revocationReasons[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("reason %d", code)
+}
+
+// RevocationChecker asks a certificate's OCSP responders, then its CRL distribution
+// points, whether it has been revoked. Answers are cached as DER files in CacheDir until
+// their nextUpdate.
+type RevocationChecker struct {
+	Client   *http.Client
+	CacheDir string
+	Grace    time.Duration
+	Now      func() time.Time
+}
+
+// GetRevocationCacheDir returns the cache directory next to config.json, creating it if needed
+func GetRevocationCacheDir() (string, error) {
+	cfgPath, err := GetConfigPath()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(filepath.Dir(cfgPath), "revocation")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// NewRevocationChecker returns a checker using the app cache dir and the configured grace period
+func NewRevocationChecker(settings RevocationSettings) (*RevocationChecker, error) {
+	grace, err := ParseRevocationGrace(settings.Grace)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := GetRevocationCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &RevocationChecker{
+		Client:   &http.Client{Timeout: revocationFetchTimeout},
+		CacheDir: dir,
+		Grace:    grace,
+		Now:      time.Now,
+	}, nil
+}
+
+// CheckP12Revocation checks the P12 certificate, finding its issuer among the P12's
+// CA certificates and the trusted root CAs
+func CheckP12Revocation(settings RevocationSettings, info *P12Info) *RevocationStatus {
+	c, err := NewRevocationChecker(settings)
+	if err != nil {
+		return &RevocationStatus{State: RevocationUnknown, Err: err}
+	}
+
+	pool := append([]*x509.Certificate(nil), info.Chain...)
+	if path, err := GetTrustedRootsPath(); err == nil {
+		if roots, err := LoadTrustedRoots(path); err == nil {
+			pool = append(pool, roots...)
+		}
+	}
+	path := buildPath(info.Certificate, pool)
+	if len(path) < 2 {
+		return &RevocationStatus{State: RevocationUnknown, Err: errors.New("issuer certificate not found in the P12 or trusted root CAs")}
+	}
+	return c.Check(info.Certificate, path[1])
+}
+
+// Check returns the revocation state of cert, which issuer signed
+func (c *RevocationChecker) Check(cert, issuer *x509.Certificate) *RevocationStatus {
+	var errs []string
+	for _, url := range cert.OCSPServer {
+		if !isHTTPURL(url) {
+			continue
+		}
+		s, err := c.query("OCSP "+url, "ocsp-"+hashName(cert.Raw), func() ([]byte, error) {
+			return c.fetchOCSP(url, cert, issuer)
+		}, func(der []byte) (*RevocationStatus, error) {
+			return parseOCSP(der, cert, issuer)
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if s.State != RevocationUnknown {
+			return s
+		}
+		errs = append(errs, fmt.Sprintf("OCSP %s: responder does not know the certificate", url))
+	}
+
+	for _, url := range cert.CRLDistributionPoints {
+		if !isHTTPURL(url) {
+			continue
+		}
+		s, err := c.query("CRL "+url, "crl-"+hashName([]byte(url)), func() ([]byte, error) {
+			return c.fetch(http.MethodGet, url, "", nil)
+		}, func(der []byte) (*RevocationStatus, error) {
+			return parseCRL(der, cert, issuer)
+		})
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return s
+	}
+
+	if len(errs) == 0 {
+		errs = append(errs, "certificate has no HTTP OCSP responder or CRL distribution point")
+	}
+	return &RevocationStatus{State: RevocationUnknown, Err: errors.New(strings.Join(errs, "; "))}
+}
+
+// query answers from the cache while it is fresh, otherwise fetches and caches a new
+// response, falling back to a cached one within the grace period if the fetch fails or
+// the response is out of date. A fetched revocation is never overruled by the cache.
+func (c *RevocationChecker) query(source, cacheName string, fetch func() ([]byte, error), parse func([]byte) (*RevocationStatus, error)) (*RevocationStatus, error) {
+	now := c.Now()
+	cachePath := filepath.Join(c.CacheDir, cacheName)
+
+	var cached *RevocationStatus
+	if der, err := os.ReadFile(cachePath); err == nil {
+		if s, err := parse(der); err == nil {
+			cached = s
+			if now.Before(s.NextUpdate) {
+				s.Source, s.Cached = source, true
+				return s, nil
+			}
+		}
+	}
+
+	der, err := fetch()
+	if err == nil {
+		var s *RevocationStatus
+		if s, err = parse(der); err == nil {
+			switch {
+			case s.ThisUpdate.After(now.Add(revocationClockSkew)):
+				err = fmt.Errorf("response is not valid until %s", s.ThisUpdate.Local().Format(time.DateTime))
+			case now.After(s.NextUpdate):
+				// Over plain HTTP this may be an old answer replayed from before a revocation
+				err = fmt.Errorf("response expired on %s", s.NextUpdate.Local().Format(time.DateTime))
+			default:
+				// A failed cache write only costs a refetch next time
+				_ = os.WriteFile(cachePath, der, 0600)
+				s.Source = source
+				return s, nil
+			}
+			// A signed "revoked" stands whatever its timing; a responder with a skewed
+			// clock must not let a cached "good" win. It is not cached.
+			if s.State == RevocationRevoked {
+				s.Source = fmt.Sprintf("%s, although the %v", source, err)
+				return s, nil
+			}
+		}
+	}
+
+	if cached != nil && now.Before(cached.NextUpdate.Add(c.Grace)) {
+		cached.Source, cached.Cached, cached.Offline = source, true, true
+		return cached, nil
+	}
+	return nil, fmt.Errorf("%s: %w", source, err)
+}
+
+func (c *RevocationChecker) fetchOCSP(url string, cert, issuer *x509.Certificate) ([]byte, error) {
+	// The default SHA-1 CertID is the one every responder understands
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.fetch(http.MethodPost, url, "application/ocsp-request", req)
+}
+
+func (c *RevocationChecker) fetch(method, url, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("User-Agent", AppName+"/"+AppVersion)
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, revocationMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > revocationMaxSize {
+		return nil, errors.New("response too large")
+	}
+	return data, nil
+}
+
+// parseOCSP verifies an OCSP response for cert, signed by issuer or a responder it delegated to
+func parseOCSP(der []byte, cert, issuer *x509.Certificate) (*RevocationStatus, error) {
+	resp, err := ocsp.ParseResponseForCert(der, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+
+	s := &RevocationStatus{ThisUpdate: resp.ThisUpdate, NextUpdate: resp.NextUpdate}
+	if s.NextUpdate.IsZero() {
+		s.NextUpdate = resp.ThisUpdate.Add(revocationDefaultLifetime)
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		s.State = RevocationGood
+	case ocsp.Revoked:
+		s.State = RevocationRevoked
+		s.RevokedAt = resp.RevokedAt
+		s.Reason = resp.RevocationReason
+	default:
+		s.State = RevocationUnknown
+	}
+	return s, nil
+}
+
+// parseCRL verifies a CRL signed by issuer and looks for cert's serial number in it
+func parseCRL(data []byte, cert, issuer *x509.Certificate) (*RevocationStatus, error) {
+	if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %w", err)
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return nil, fmt.Errorf("CRL not signed by %s: %w", certName(issuer), err)
+	}
+
+	s := &RevocationStatus{State: RevocationGood, ThisUpdate: crl.ThisUpdate, NextUpdate: crl.NextUpdate}
+	if s.NextUpdate.IsZero() {
+		s.NextUpdate = crl.ThisUpdate.Add(revocationDefaultLifetime)
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			s.State = RevocationRevoked
+			s.RevokedAt = entry.RevocationTime
+			s.Reason = entry.ReasonCode
+			break
+		}
+	}
+	return s, nil
+}
+
+// RevocationError is returned when a certificate must not be used after a revocation check
+type RevocationError struct {
+	Status *RevocationStatus
+}
+
+func (e *RevocationError) Error() string {
+	if e.Status.State == RevocationRevoked {
+		return "certificate has been revoked: " + e.Status.String()
+	}
+	return fmt.Sprintf("certificate revocation status could not be determined: %v", e.Status.Err)
+}
+
+// Enforce returns a RevocationError when the status forbids using the certificate:
+// always when revoked, and when unknown if settings require a status
+func (s *RevocationStatus) Enforce(settings RevocationSettings) error {
+	if s.State == RevocationRevoked || (s.State == RevocationUnknown && settings.RequireStatus) {
+		return &RevocationError{Status: s}
+	}
+	return nil
+}
+
+func isHTTPURL(u string) bool {
+	return strings.HasPrefix(u, "http://") || strings.HasPrefix(u, "https://")
+}
+
+func hashName(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// testResponder is an OCSP responder and CRL server for a test CA. Its answers are
+// signed for whatever state the test sets.
+type testResponder struct {
+	t     *testing.T
+	caKey *ecdsa.PrivateKey
+	ca    *x509.Certificate
+	leaf  *x509.Certificate
+
+	mu         sync.Mutex
+	down       bool
+	revoked    bool
+	thisUpdate time.Time
+	nextUpdate time.Time
+	hits       int
+}
+
+func newTestResponder(t *testing.T) (*testResponder, *httptest.Server) {
+	r := &testResponder{t: t, thisUpdate: time.Now().Add(-time.Minute), nextUpdate: time.Now().Add(time.Hour)}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	var err error
+	if r.caKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Revocation Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(48 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &r.caKey.PublicKey, r.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ca, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(4242),
+		Subject:               pkix.Name{CommonName: "Revocation Test User"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		OCSPServer:            []string{srv.URL + "/ocsp"},
+		CRLDistributionPoints: []string{srv.URL + "/crl"},
+	}
+	if der, err = x509.CreateCertificate(rand.Reader, leafTmpl, r.ca, &leafKey.PublicKey, r.caKey); err != nil {
+		t.Fatal(err)
+	}
+	if r.leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return r, srv
+}
+
+func (r *testResponder) set(f func(r *testResponder)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f(r)
+}
+
+func (r *testResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hits++
+	if r.down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+
+	var der []byte
+	var err error
+	switch req.URL.Path {
+	case "/ocsp":
+		body, _ := io.ReadAll(req.Body)
+		ocspReq, perr := ocsp.ParseRequest(body)
+		if perr != nil {
+			http.Error(w, perr.Error(), http.StatusBadRequest)
+			return
+		}
+		tmpl := ocsp.Response{Status: ocsp.Good, SerialNumber: ocspReq.SerialNumber, ThisUpdate: r.thisUpdate, NextUpdate: r.nextUpdate}
+		if r.revoked {
+			tmpl.Status, tmpl.RevokedAt, tmpl.RevocationReason = ocsp.Revoked, r.thisUpdate, ocsp.KeyCompromise
+		}
+		der, err = ocsp.CreateResponse(r.ca, r.ca, tmpl, r.caKey)
+	case "/crl":
+		tmpl := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: r.thisUpdate, NextUpdate: r.nextUpdate}
+		if r.revoked {
+			tmpl.RevokedCertificateEntries = []x509.RevocationListEntry{{SerialNumber: r.leaf.SerialNumber, RevocationTime: r.thisUpdate, ReasonCode: ocsp.KeyCompromise}}
+		}
+		der, err = x509.CreateRevocationList(rand.Reader, tmpl, r.ca, r.caKey)
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if err != nil {
+		r.t.Error(err)
+	}
+	w.Write(der)
+}
+
+// newTestChecker returns a checker with a fresh cache and a 3h grace period whose clock is *now
+func newTestChecker(t *testing.T, srv *httptest.Server, now *time.Time) *RevocationChecker {
+	return &RevocationChecker{Client: srv.Client(), CacheDir: t.TempDir(), Grace: 3 * time.Hour, Now: func() time.Time { return *now }}
+}
+
+func TestRevocationGood(t *testing.T) {
+	r, srv := newTestResponder(t)
+	now := time.Now()
+	c := newTestChecker(t, srv, &now)
+
+	s := c.Check(r.leaf, r.ca)
+	if s.State != RevocationGood || s.Cached || !strings.HasPrefix(s.Source, "OCSP ") {
+		t.Fatalf("first check = %s, want good per OCSP", s)
+	}
+	if s := c.Check(r.leaf, r.ca); s.State != RevocationGood || !s.Cached {
+		t.Errorf("second check = %s, want good from the cache", s)
+	}
+	r.set(func(r *testResponder) {
+		if r.hits != 1 {
+			t.Errorf("responder was asked %d times, want 1", r.hits)
+		}
+	})
+
+	if runtime.GOOS != "windows" {
+		files, _ := filepath.Glob(filepath.Join(c.CacheDir, "*"))
+		for _, f := range files {
+			if st, err := os.Stat(f); err != nil {
+				t.Error(err)
+			} else if st.Mode().Perm() != 0600 {
+				t.Errorf("cache file %s has mode %v, want 0600", filepath.Base(f), st.Mode().Perm())
+			}
+		}
+	}
+}
+
+func TestRevocationRevoked(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		source string
+		leaf   func(r *testResponder) *x509.Certificate
+	}{
+		{"OCSP", "OCSP ", func(r *testResponder) *x509.Certificate { return r.leaf }},
+		{"CRL", "CRL ", func(r *testResponder) *x509.Certificate {
+			leaf := *r.leaf
+			leaf.OCSPServer = nil
+			return &leaf
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, srv := newTestResponder(t)
+			r.set(func(r *testResponder) { r.revoked = true })
+			now := time.Now()
+			c := newTestChecker(t, srv, &now)
+
+			s := c.Check(tt.leaf(r), r.ca)
+			if s.State != RevocationRevoked || !strings.HasPrefix(s.Source, tt.source) {
+				t.Fatalf("Check = %s, want revoked per %s", s, tt.name)
+			}
+			if s.Reason != ocsp.KeyCompromise {
+				t.Errorf("reason = %s, want key compromise", revocationReason(s.Reason))
+			}
+			if err := s.Enforce(RevocationSettings{Enabled: true}); err == nil {
+				t.Error("Enforce allowed a revoked certificate")
+			}
+		})
+	}
+}
+
+// An old signed "good" answer replayed by someone on the path to a plain-HTTP
+// responder must not be taken as current
+func TestRevocationStaleResponse(t *testing.T) {
+	r, srv := newTestResponder(t)
+	now := time.Now()
+	r.set(func(r *testResponder) {
+		r.thisUpdate, r.nextUpdate = now.Add(-48*time.Hour), now.Add(-24*time.Hour)
+	})
+	c := newTestChecker(t, srv, &now)
+
+	s := c.Check(r.leaf, r.ca)
+	if s.State != RevocationUnknown {
+		t.Fatalf("Check = %s, want unknown", s)
+	}
+	if !strings.Contains(s.Err.Error(), "expired") {
+		t.Errorf("error = %v, want it to say the response expired", s.Err)
+	}
+	if err := s.Enforce(RevocationSettings{Enabled: true, RequireStatus: true}); err == nil {
+		t.Error("Enforce allowed an unknown status with RequireStatus")
+	}
+	if files, _ := filepath.Glob(filepath.Join(c.CacheDir, "*")); len(files) != 0 {
+		t.Errorf("stale responses were cached: %v", files)
+	}
+}
+
+func TestRevocationOfflineGrace(t *testing.T) {
+	r, srv := newTestResponder(t)
+	now := time.Now()
+	c := newTestChecker(t, srv, &now)
+
+	if s := c.Check(r.leaf, r.ca); s.State != RevocationGood {
+		t.Fatalf("online check = %s, want good", s)
+	}
+
+	// Past nextUpdate with the responder down: the cached answer holds for the grace period
+	r.set(func(r *testResponder) { r.down = true })
+	now = now.Add(2 * time.Hour)
+	if s := c.Check(r.leaf, r.ca); s.State != RevocationGood || !s.Offline {
+		t.Errorf("check within grace = %s, want good (offline)", s)
+	}
+
+	now = now.Add(3 * time.Hour)
+	if s := c.Check(r.leaf, r.ca); s.State != RevocationUnknown {
+		t.Errorf("check past grace = %s, want unknown", s)
+	}
+}
+
+// A revocation from a responder whose clock is off must not be traded for the cached
+// "good" of the grace period
+func TestRevocationRevokedBadTiming(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		timing func(now time.Time) (thisUpdate, nextUpdate time.Time)
+		note   string
+	}{
+		{"ahead", func(now time.Time) (time.Time, time.Time) { return now.Add(time.Hour), now.Add(2 * time.Hour) }, "not valid until"},
+		{"behind", func(now time.Time) (time.Time, time.Time) { return now.Add(-2 * time.Hour), now.Add(-time.Hour) }, "expired on"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, srv := newTestResponder(t)
+			now := time.Now()
+			c := newTestChecker(t, srv, &now)
+			if s := c.Check(r.leaf, r.ca); s.State != RevocationGood {
+				t.Fatalf("first check = %s, want good", s)
+			}
+
+			now = now.Add(2 * time.Hour)
+			r.set(func(r *testResponder) {
+				r.revoked = true
+				r.thisUpdate, r.nextUpdate = tt.timing(now)
+			})
+			s := c.Check(r.leaf, r.ca)
+			if s.State != RevocationRevoked || s.Cached {
+				t.Fatalf("check = %s, want revoked from the responder", s)
+			}
+			if !strings.Contains(s.String(), tt.note) {
+				t.Errorf("status %q does not report the timing problem %q", s, tt.note)
+			}
+		})
+	}
+}
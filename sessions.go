@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session states shown in the sessions list
+const (
+	SessionConnecting   = "Connecting"
+	SessionConnected    = "Connected"
+	SessionReconnecting = "Reconnecting"
+)
+
+// Session is one running tunnel started from a profile
+type Session struct {
+	ID        int
+	Profile   string
+	Host      string
+	Target    string
+	LocalPort string
+	Started   time.Time
+
+	// TunnelOnly sessions launch no RDP client; another tool uses Endpoint
+	TunnelOnly bool
+
+	logFunc func(string)
+	cancel  context.CancelFunc
+	ctx     context.Context
+	manager *SessionManager
+
+	mu       sync.Mutex
+	status   string
+	forwards []*ForwardCounter
+}
+
+// Log writes msg to the session's log function, prefixed with the profile name
+func (s *Session) Log(msg string) {
+	s.logFunc(fmt.Sprintf("[%s] %s", s.Profile, msg))
+}
+
+// Status returns the session's current state
+func (s *Session) Status() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// SetStatus updates the session's state and notifies the manager's listener
+func (s *Session) SetStatus(status string) {
+	s.mu.Lock()
+	s.status = status
+	s.mu.Unlock()
+	s.manager.changed()
+}
+
+// SetForwards records the session's forward counters for the sessions list
+func (s *Session) SetForwards(counters []*ForwardCounter) {
+	s.mu.Lock()
+	s.forwards = counters
+	s.mu.Unlock()
+	s.manager.changed()
+}
+
+// Forwards returns the session's forward counters, once the tunnel is listening
+func (s *Session) Forwards() []*ForwardCounter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.forwards
+}
+
+// Stop cancels the session; its goroutine ends shortly after
+func (s *Session) Stop() {
+	s.cancel()
+}
+
+// Endpoint is the local address clients connect to
+func (s *Session) Endpoint() string {
+	return "localhost:" + s.LocalPort
+}
+
+// SessionManager runs several tunnels in parallel, each with its own context
+type SessionManager struct {
+	// OnChange is called from any goroutine when sessions start, end or change state
+	OnChange func()
+	// OnEnded is called from the session goroutine once a session has been removed.
+	// err is nil for sessions ended by the user.
+	OnEnded func(s *Session, err error)
+
+	mu       sync.Mutex
+	sessions []*Session
+	nextID   int
+}
+
+// Start registers s and runs fn in a new goroutine. The session is removed when fn returns.
+func (m *SessionManager) Start(s *Session, logFunc func(string), fn func(ctx context.Context, s *Session) error) error {
+	m.mu.Lock()
+	for _, other := range m.sessions {
+		if other.Profile == s.Profile {
+			m.mu.Unlock()
+			return fmt.Errorf("profile %q is already connected", s.Profile)
+		}
+		if other.LocalPort == s.LocalPort {
+			m.mu.Unlock()
+			return fmt.Errorf("local port %s is already used by profile %q", s.LocalPort, other.Profile)
+		}
+	}
+
+	m.nextID++
+	s.ID = m.nextID
+	s.Started = time.Now()
+	s.status = SessionConnecting
+	s.logFunc = logFunc
+	s.manager = m
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	m.sessions = append(m.sessions, s)
+	m.mu.Unlock()
+
+	m.changed()
+
+	go func() {
+		err := fn(s.ctx, s)
+		s.cancel()
+
+		// Ignore expected exit codes from user-cancelled RDP sessions
+		cancelled := s.ctx.Err() == context.Canceled
+		if err == context.Canceled || (err != nil && cancelled && strings.Contains(err.Error(), "exit status 1")) {
+			err = nil
+		}
+
+		m.remove(s)
+		if m.OnEnded != nil {
+			m.OnEnded(s, err)
+		}
+		m.changed()
+	}()
+	return nil
+}
+
+func (m *SessionManager) remove(s *Session) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.sessions {
+		if other == s {
+			m.sessions = append(m.sessions[:i], m.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *SessionManager) changed() {
+	if m.OnChange != nil {
+		m.OnChange()
+	}
+}
+
+// Sessions returns a snapshot of running sessions in start order
+func (m *SessionManager) Sessions() []*Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Session(nil), m.sessions...)
+}
+
+// ByProfile returns the running session for a profile, or nil
+func (m *SessionManager) ByProfile(name string) *Session {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, s := range m.sessions {
+		if s.Profile == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Active reports how many sessions are running
+func (m *SessionManager) Active() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+// StopAll cancels every running session
+func (m *SessionManager) StopAll() {
+	for _, s := range m.Sessions() {
+		s.Stop()
+	}
+}
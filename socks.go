@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultSOCKSPort is the local port of the built-in SOCKS5 proxy
+const DefaultSOCKSPort = "1080"
+
+// SOCKS5 protocol values (RFC 1928, RFC 1929)
+const (
+	socksVersion = 5
+
+	socksMethodNone         = 0x00
+	socksMethodPassword     = 0x02
+	socksMethodNoAcceptable = 0xFF
+
+	socksPasswordVersion = 1
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded          = 0x00
+	socksReplyNotAllowed         = 0x02
+	socksReplyHostUnreachable    = 0x04
+	socksReplyCommandUnsupported = 0x07
+	socksReplyAddrUnsupported    = 0x08
+)
+
+// socksHandshakeTimeout bounds how long a client may take to send its request
+const socksHandshakeTimeout = 30 * time.Second
+
+// SOCKSSettings configure a profile's built-in SOCKS5 proxy, which lets a browser reach
+// internal web consoles through the SSH server
+type SOCKSSettings struct {
+	Enabled  bool   `json:"enabled,omitempty"`
+	Port     string `json:"port,omitempty"`     // default DefaultSOCKSPort
+	Username string `json:"username,omitempty"` // empty: no authentication
+	Password string `json:"password,omitempty"`
+
+	// Rules are "allow PATTERN" or "deny PATTERN" lines, checked in order (see ParseSOCKSRule)
+	Rules []string `json:"rules,omitempty"`
+}
+
+// ListenAddress is the proxy's local address
+func (s SOCKSSettings) ListenAddress() string {
+	port := s.Port
+	if port == "" {
+		port = DefaultSOCKSPort
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// SOCKSRule allows or denies destinations matching a host pattern or CIDR and an optional port
+type SOCKSRule struct {
+	Allow bool
+	Host  string     // host name pattern with * and ?, or an IP address
+	Net   *net.IPNet // set for CIDR rules
+	Port  string     // empty matches any port
+	text  string
+}
+
+func (r SOCKSRule) String() string { return r.text }
+
+// ParseSOCKSRule parses "allow|deny HOST[:PORT]" where HOST is a name pattern
+// (*.corp.example.com), an IP address or a CIDR (10.0.0.0/8, [fd00::/8]:443), or * for any
+func ParseSOCKSRule(line string) (SOCKSRule, error) {
+	r := SOCKSRule{text: strings.Join(strings.Fields(line), " ")}
+	action, pattern, ok := strings.Cut(r.text, " ")
+	switch strings.ToLower(action) {
+	case "allow":
+		r.Allow = true
+	case "deny":
+	default:
+		return r, fmt.Errorf("rule %q must start with allow or deny", line)
+	}
+	if !ok || strings.Contains(pattern, " ") {
+		return r, fmt.Errorf("rule %q needs exactly one destination", line)
+	}
+
+	r.Host = pattern
+	if host, port, err := net.SplitHostPort(pattern); err == nil && (strings.HasPrefix(pattern, "[") || strings.Count(pattern, ":") == 1) {
+		r.Host, r.Port = host, port
+		if r.Port == "*" {
+			r.Port = ""
+		} else if n, err := strconv.Atoi(r.Port); err != nil || n < 1 || n > 65535 {
+			return r, fmt.Errorf("rule %q: invalid port %q", line, port)
+		}
+	}
+	if strings.Contains(r.Host, "/") {
+		_, ipNet, err := net.ParseCIDR(r.Host)
+		if err != nil {
+			return r, fmt.Errorf("rule %q: %w", line, err)
+		}
+		r.Net = ipNet
+	}
+	return r, nil
+}
+
+// ParseSOCKSRules parses one rule per line. Blank lines and lines starting with # are ignored.
+func ParseSOCKSRules(lines []string) ([]SOCKSRule, error) {
+	var rules []SOCKSRule
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		r, err := ParseSOCKSRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// matches reports whether the rule applies to host and port. Host names are resolved
+// by the SSH server, so whether a name falls under an IP or CIDR rule is unknown here:
+// such a deny rule matches every name, so none gets past "deny 10.0.0.0/8", and such an
+// allow rule matches none.
+func (r SOCKSRule) matches(host, port string) bool {
+	if r.Port != "" && r.Port != port {
+		return false
+	}
+	ruleIP := net.ParseIP(r.Host)
+	if r.Net == nil && ruleIP == nil {
+		return matchHostPattern(r.Host, host)
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return !r.Allow
+	case r.Net != nil:
+		return r.Net.Contains(ip)
+	}
+	return ruleIP.Equal(ip)
+}
+
+// SOCKSServer answers SOCKS5 CONNECT requests, opening the connections over SSH.
+// The zero value accepts any client and destination.
+type SOCKSServer struct {
+	Username string // if set, clients must authenticate with Username and Password
+	Password string
+	Rules    []SOCKSRule
+}
+
+// NewSOCKSServer creates the server for a profile's proxy settings
+func NewSOCKSServer(s SOCKSSettings) (*SOCKSServer, error) {
+	rules, err := ParseSOCKSRules(s.Rules)
+	if err != nil {
+		return nil, err
+	}
+	return &SOCKSServer{Username: s.Username, Password: s.Password, Rules: rules}, nil
+}
+
+// Allowed checks addr (host:port) against the rules; the first match decides. Without a
+// match a destination is denied if any allow rule exists, so a list of allow rules is a
+// whitelist and a list of deny rules a blacklist. The deciding rule is returned if any.
+func (s *SOCKSServer) Allowed(addr string) (bool, *SOCKSRule) {
+	host, port, _ := net.SplitHostPort(addr)
+	anyAllow := false
+	for i, r := range s.Rules {
+		if r.matches(host, port) {
+			return r.Allow, &s.Rules[i]
+		}
+		anyAllow = anyAllow || r.Allow
+	}
+	return !anyAllow, nil
+}
+
+// Handshake answers a SOCKS5 CONNECT request on conn and opens the requested connection
+// with dial. It returns the requested address and the open connection.
+func (s *SOCKSServer) Handshake(ctx context.Context, conn net.Conn, dial func(ctx context.Context, network, addr string) (net.Conn, error)) (string, net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+
+	// Greeting: version, number of methods, methods
+	var hdr [2]byte
+	if _, err := io.ReadFull(conn, hdr[:]); err != nil {
+		return "", nil, fmt.Errorf("SOCKS greeting: %w", err)
+	}
+	if hdr[0] != socksVersion {
+		return "", nil, fmt.Errorf("SOCKS version %d not supported", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", nil, fmt.Errorf("SOCKS greeting: %w", err)
+	}
+	want := byte(socksMethodNone)
+	if s.Username != "" {
+		want = socksMethodPassword
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == want {
+			method = want
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", nil, err
+	}
+	if method == socksMethodNoAcceptable {
+		return "", nil, errors.New("SOCKS client offers no supported authentication method")
+	}
+	if method == socksMethodPassword {
+		if err := s.authenticate(conn); err != nil {
+			return "", nil, err
+		}
+	}
+
+	// Request: version, command, reserved, address
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return "", nil, fmt.Errorf("SOCKS request: %w", err)
+	}
+	addr, err := readSOCKSAddr(conn, req[3])
+	if err != nil {
+		socksReply(conn, socksReplyAddrUnsupported)
+		return "", nil, err
+	}
+	if req[1] != socksCmdConnect {
+		socksReply(conn, socksReplyCommandUnsupported)
+		return addr, nil, fmt.Errorf("SOCKS command %d not supported", req[1])
+	}
+	if ok, rule := s.Allowed(addr); !ok {
+		socksReply(conn, socksReplyNotAllowed)
+		if rule != nil {
+			return addr, nil, fmt.Errorf("denied by rule %q", rule)
+		}
+		return addr, nil, errors.New("denied: no allow rule matches")
+	}
+
+	target, err := dial(ctx, "tcp", addr)
+	if err != nil {
+		socksReply(conn, socksReplyHostUnreachable)
+		return addr, nil, err
+	}
+	if err := socksReply(conn, socksReplySucceeded); err != nil {
+		target.Close()
+		return addr, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return addr, target, nil
+}
+
+// authenticate runs the username/password subnegotiation (RFC 1929)
+func (s *SOCKSServer) authenticate(conn net.Conn) error {
+	readField := func() ([]byte, error) {
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n[0])
+		_, err := io.ReadFull(conn, b)
+		return b, err
+	}
+
+	var ver [1]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return fmt.Errorf("SOCKS authentication: %w", err)
+	}
+	user, err := readField()
+	if err != nil {
+		return fmt.Errorf("SOCKS authentication: %w", err)
+	}
+	pass, err := readField()
+	if err != nil {
+		return fmt.Errorf("SOCKS authentication: %w", err)
+	}
+
+	userOK := subtle.ConstantTimeCompare(user, []byte(s.Username)) == 1
+	passOK := subtle.ConstantTimeCompare(pass, []byte(s.Password)) == 1
+	if ver[0] != socksPasswordVersion || !userOK || !passOK {
+		conn.Write([]byte{socksPasswordVersion, 1})
+		return fmt.Errorf("SOCKS authentication failed for user %q", user)
+	}
+	_, err = conn.Write([]byte{socksPasswordVersion, 0})
+	return err
+}
+
+// startSOCKSProxy runs the profile's built-in SOCKS5 proxy until ctx ends, logging every
+// connection with the rule decision and the bytes transferred
+func startSOCKSProxy(ctx context.Context, client *supervisedClient, settings SOCKSSettings, logFunc func(string)) (*ForwardCounter, error) {
+	server, err := NewSOCKSServer(settings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SOCKS proxy rules: %w", err)
+	}
+	listener, err := net.Listen("tcp", settings.ListenAddress())
+	if err != nil {
+		return nil, fmt.Errorf("failed to start SOCKS proxy on %s: %w", settings.ListenAddress(), err)
+	}
+	auth := "no authentication"
+	if server.Username != "" {
+		auth = "username/password"
+	}
+	logFunc(fmt.Sprintf("SOCKS5 proxy listening on %s (%s, %d rules)", listener.Addr(), auth, len(server.Rules)))
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	counter := newForwardCounter(Forward{Kind: ForwardDynamic, Port: port}, "SOCKS5 proxy")
+	serveListener(ctx, listener, func(conn net.Conn) {
+		addr, target, err := server.Handshake(ctx, conn, client.Dial)
+		if err != nil {
+			conn.Close()
+			if addr == "" {
+				logFunc(fmt.Sprintf("SOCKS5 %s: %v", conn.RemoteAddr(), err))
+			} else {
+				logFunc(fmt.Sprintf("SOCKS5 %s -> %s: %v", conn.RemoteAddr(), addr, err))
+			}
+			return
+		}
+		logFunc(fmt.Sprintf("SOCKS5 %s -> %s: connected", conn.RemoteAddr(), addr))
+		sent, received := relay(conn, target, counter)
+		logFunc(fmt.Sprintf("SOCKS5 %s -> %s: closed. Sent %d, received %d bytes", conn.RemoteAddr(), addr, sent, received))
+	})
+	return counter, nil
+}
+
+// readSOCKSAddr reads the address and port of a request with address type atyp
+func readSOCKSAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("SOCKS address type %d not supported", atyp)
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socksReply sends a reply with an unspecified bound address, which clients ignore for CONNECT
+func socksReply(w io.Writer, code byte) error {
+	_, err := w.Write([]byte{socksVersion, code, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestSOCKSAllowed(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []string
+		addr  string
+		want  bool
+	}{
+		{"no rules", nil, "intranet.corp.example.com:443", true},
+		{"name allowed", []string{"allow *.corp.example.com:443"}, "intranet.corp.example.com:443", true},
+		{"wrong port", []string{"allow *.corp.example.com:443"}, "intranet.corp.example.com:80", false},
+		{"ip in cidr denied", []string{"deny 10.0.0.0/8"}, "10.1.2.3:443", false},
+		{"ip outside cidr", []string{"deny 10.0.0.0/8"}, "192.168.1.1:443", true},
+		{"ip in cidr allowed", []string{"allow 10.20.0.0/16"}, "10.20.1.1:80", true},
+		{"ipv6 cidr", []string{"deny [fd00::/8]:443"}, "[fd00::1]:443", false},
+		{"exact ip", []string{"deny 10.1.2.3"}, "10.1.2.3:22", false},
+
+		// Names are resolved by the SSH server and could land in a denied range
+		{"name at cidr deny", []string{"deny 10.0.0.0/8"}, "db.internal:5432", false},
+		{"name at ip deny", []string{"deny 10.1.2.3"}, "db.internal:5432", false},
+		{"name at cidr deny other port", []string{"deny 10.0.0.0/8:22"}, "db.internal:5432", true},
+		{"name rule first", []string{"allow *.corp.example.com", "deny 10.0.0.0/8"}, "web.corp.example.com:443", true},
+		{"name not allowed by cidr", []string{"allow 10.20.0.0/16"}, "web.corp.example.com:443", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSOCKSServer(SOCKSSettings{Rules: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, rule := s.Allowed(tt.addr); got != tt.want {
+				t.Errorf("Allowed(%s) = %v (rule %v), want %v", tt.addr, got, rule, tt.want)
+			}
+		})
+	}
+}
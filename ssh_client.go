@@ -3,159 +3,323 @@ package main
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
-	"io"
 	"net"
-	"os"
-	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
-// getSSHConfig creates SSH client configuration with cert-based auth
-// WARNING: Uses InsecureIgnoreHostKey - should implement known_hosts verification in production
-func getSSHConfig(user string, p12Info *P12Info) (*ssh.ClientConfig, error) {
-	signer, err := ssh.NewSignerFromKey(p12Info.PrivateKey)
+// getSSHConfig creates SSH client configuration with cert-based auth (see NewSigner).
+// Host keys are verified against the app known_hosts file; unknown hosts go to the prompter.
+func getSSHConfig(addr, user string, signer ssh.Signer, keyring agent.Agent, prompter Prompter) (*ssh.ClientConfig, error) {
+	hostKeyCallback, err := newHostKeyCallback(prompter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create signer from private key: %w", err)
+		return nil, err
 	}
 
 	return &ssh.ClientConfig{
-		User:            user,
-		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
-		Timeout:         5 * time.Second,
+		User:              user,
+		Auth:              authMethods(addr, user, signer, keyring, prompter),
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: knownHostKeyAlgorithms(addr),
+		Timeout:           5 * time.Second,
 	}, nil
 }
 
-// TestConnection verifies SSH connectivity and authentication
-func TestConnection(host, user string, p12Info *P12Info) (string, error) {
-	config, err := getSSHConfig(user, p12Info)
+// ConnectOptions describes how to reach and authenticate to the SSH server
+type ConnectOptions struct {
+	Host      string // host or host:port of the target SSH server
+	User      string
+	JumpHosts []JumpHost // bastions dialed in order before Host
+	Signer    ssh.Signer
+	Prompter  Prompter
+
+	// RefreshSigner, if set, is called before each reconnect so short-lived
+	// certificates can be renewed
+	RefreshSigner func() (ssh.Signer, error)
+
+	// UseSSHConfig treats Host as an alias in ~/.ssh/config (see applySSHConfig)
+	UseSSHConfig bool
+	// KeepAliveInterval overrides the default keep-alive interval when set
+	KeepAliveInterval time.Duration
+	// Proxy carries the first hop through an HTTP CONNECT or SOCKS5 proxy (see ParseProxy)
+	Proxy string
+
+	// UseAgent authenticates with the keys held by the running ssh-agent; Signer may be nil
+	UseAgent bool
+	// ForwardAgent makes the local ssh-agent available on the server, like ssh -A
+	ForwardAgent bool
+}
+
+// Address returns the target host:port, defaulting to port 22
+func (o ConnectOptions) Address() string {
+	if _, _, err := net.SplitHostPort(o.Host); err != nil {
+		return o.Host + ":22"
+	}
+	return o.Host
+}
+
+// DefaultRDPTarget is dialed from the SSH server when no remote target is configured
+const DefaultRDPTarget = "localhost:3389"
+
+// TunnelOptions describes the local forward established over the SSH connection
+type TunnelOptions struct {
+	LocalPort    string
+	RemoteTarget string // host:port dialed from the SSH server; empty means DefaultRDPTarget
+
+	// OnStatus, if set, receives SessionConnected or "Reconnecting (attempt N)"
+	// as the SSH transport drops and recovers
+	OnStatus func(status string)
+
+	// Launcher starts the RDP client once the tunnel is up; nil auto-detects one
+	Launcher Launcher
+	Title    string // profile name, shown by clients that support a window title
+	Display  RDPSettings
+
+	// Forwards are opened over the same SSH connection next to the RDP forward
+	Forwards []Forward
+	// SOCKS configures the built-in SOCKS5 proxy
+	SOCKS SOCKSSettings
+	// OnForwards, if set, receives the counters of the RDP forward, the extra forwards and
+	// the SOCKS proxy once they are listening
+	OnForwards func(counters []*ForwardCounter)
+}
+
+// NormalizeRDPTarget validates a remote RDP target, adding the default port 3389
+// when only a host is given
+func NormalizeRDPTarget(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return DefaultRDPTarget, nil
+	}
+
+	host, port, err := net.SplitHostPort(target)
 	if err != nil {
-		return "", err
+		// Bare host name or IP (IPv6 literals may be bracketed)
+		host, port = strings.Trim(target, "[]"), "3389"
+	}
+	if host == "" {
+		return "", fmt.Errorf("RDP target %q has no host", target)
 	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("RDP target %q has an invalid port", target)
+	}
+	return net.JoinHostPort(host, port), nil
+}
 
-	addr := host
-	if _, _, err := net.SplitHostPort(host); err != nil {
-		addr = host + ":22"
+// TestConnection verifies SSH connectivity, host keys and authentication for every hop
+func TestConnection(opts ConnectOptions, logFunc func(string)) (string, error) {
+	if opts.UseSSHConfig {
+		var err error
+		if opts, _, err = applySSHConfig(opts, logFunc); err != nil {
+			return "", err
+		}
 	}
 
-	client, err := ssh.Dial("tcp", addr, config)
+	client, err := dialSSH(context.Background(), opts, logFunc)
 	if err != nil {
 		return "", fmt.Errorf("connection failed: %v", err)
 	}
 	defer client.Close()
 
-	return fmt.Sprintf("Successfully authenticated to %s as %s", host, user), nil
+	if len(opts.JumpHosts) > 0 {
+		return fmt.Sprintf("Successfully authenticated to %s as %s via %d jump host(s)", opts.Host, opts.User, len(opts.JumpHosts)), nil
+	}
+	return fmt.Sprintf("Successfully authenticated to %s as %s", opts.Host, opts.User), nil
 }
 
-// StartTunnel establishes SSH tunnel, forwards localhost:localPort to remote RDP (3389),
-// and launches mstsc.exe. Blocks until RDP client exits or context is cancelled.
-func StartTunnel(ctx context.Context, host, user, localPort string, p12Info *P12Info, logFunc func(string), onReady func()) error {
-	config, err := getSSHConfig(user, p12Info)
+// StartTunnel establishes SSH tunnel, forwards localhost:LocalPort to the remote RDP target
+// (localhost:3389 on the SSH server by default), and launches the RDP client.
+// Blocks until RDP client exits or context is cancelled. If the SSH transport drops,
+// it is re-dialed in the background while the local listener stays up.
+func StartTunnel(ctx context.Context, opts ConnectOptions, tunnel TunnelOptions, logFunc func(string), onReady func()) error {
+	remoteTarget, err := NormalizeRDPTarget(tunnel.RemoteTarget)
 	if err != nil {
 		return err
 	}
 
-	addr := host
-	if _, _, err := net.SplitHostPort(host); err != nil {
-		addr = host + ":22"
+	if opts.UseSSHConfig {
+		var forwards []Forward
+		if opts, forwards, err = applySSHConfig(opts, logFunc); err != nil {
+			return err
+		}
+		tunnel.Forwards = mergeForwards(tunnel.Forwards, forwards)
 	}
 
-	logFunc(fmt.Sprintf("Dialing SSH to %s...", addr))
-	client, err := ssh.Dial("tcp", addr, config)
+	launcher := tunnel.Launcher
+	if launcher == nil {
+		if launcher, err = NewLauncher(RDPClientAuto, ""); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	client, err := newSupervisedClient(ctx, opts, logFunc, tunnel.OnStatus)
 	if err != nil {
 		return fmt.Errorf("SSH dial failed: %w", err)
 	}
 	defer client.Close()
-	logFunc("SSH connection established.")
 
-	go func() {
-		ticker := time.NewTicker(30 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-ticker.C:
-				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
-				if err != nil {
-					logFunc(fmt.Sprintf("Keep-alive failed: %v", err))
-					return
-				}
-			}
-		}
-	}()
-
-	localAddr := "localhost:" + localPort
+	localAddr := "localhost:" + tunnel.LocalPort
 	listener, err := net.Listen("tcp", localAddr)
 	if err != nil {
 		return fmt.Errorf("failed to start local listener on %s: %w", localAddr, err)
 	}
 	defer listener.Close()
-	logFunc(fmt.Sprintf("Tunnel listening on %s -> remote:localhost:3389", localAddr))
+	logFunc(fmt.Sprintf("Tunnel listening on %s -> %s (via %s)", localAddr, remoteTarget, opts.Host))
 
+	rdpCounter := newForwardCounter(Forward{Kind: ForwardLocal, Port: tunnel.LocalPort, Target: remoteTarget}, "RDP")
+	forwards := &forwardTracker{idleSince: time.Now()}
 	go func() {
 		for {
 			localConn, err := listener.Accept()
 			if err != nil {
 				return
 			}
-			go handleForward(client, localConn, logFunc)
+			forwards.begin()
+			go func() {
+				defer forwards.end()
+				handleForward(ctx, client, localConn, remoteTarget, rdpCounter, logFunc)
+			}()
 		}
 	}()
 
-	rdpContent := fmt.Sprintf("full address:s:%s\nusername:s:%s\n", localAddr, user)
-	tmpFile, err := os.CreateTemp("", strings.ToLower(AppName)+"-*.rdp")
+	counters, err := startForwards(ctx, client, tunnel.Forwards, logFunc)
 	if err != nil {
-		return fmt.Errorf("failed to create temp rdp file: %w", err)
+		return err
+	}
+	if tunnel.SOCKS.Enabled {
+		counter, err := startSOCKSProxy(ctx, client, tunnel.SOCKS, logFunc)
+		if err != nil {
+			return err
+		}
+		counters = append(counters, counter)
+	}
+	if tunnel.OnForwards != nil {
+		tunnel.OnForwards(append([]*ForwardCounter{rdpCounter}, counters...))
 	}
-	defer os.Remove(tmpFile.Name())
 
-	if _, err := tmpFile.WriteString(rdpContent); err != nil {
-		return fmt.Errorf("failed to write rdp file: %w", err)
+	spec := LaunchSpec{Address: localAddr, User: opts.User, Title: tunnel.Title, Display: tunnel.Display}
+	cmd, cleanup, err := launcher.Command(ctx, spec)
+	if err != nil {
+		return fmt.Errorf("failed to prepare %s: %w", launcher.Name(), err)
+	}
+	if cleanup != nil {
+		defer cleanup()
 	}
-	tmpFile.Close()
 
 	if onReady != nil {
 		onReady()
 	}
 
-	logFunc(fmt.Sprintf("Launching Remote Desktop Client with %s...", tmpFile.Name()))
-	cmd := exec.CommandContext(ctx, "mstsc.exe", tmpFile.Name())
+	if cmd == nil {
+		logFunc(fmt.Sprintf("Tunnel-only mode: connect your client to %s; the tunnel stays open until disconnected.", localAddr))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err = <-client.failed:
+			return fmt.Errorf("SSH reconnect failed: %w", err)
+		}
+	}
+
+	logFunc(fmt.Sprintf("Launching Remote Desktop Client: %s", strings.Join(cmd.Args, " ")))
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start mstsc: %w", err)
+		return fmt.Errorf("failed to start %s: %w", launcher.Name(), err)
 	}
 
-	err = cmd.Wait()
-	logFunc("Remote Desktop Client exited.")
-	return err
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-exited:
+		if err == nil && launcher.Detaches() {
+			logFunc(fmt.Sprintf("%s handed the session to its running instance; keeping the tunnel open while it is in use.", launcher.Name()))
+			err = forwards.waitIdle(ctx, client.failed)
+		}
+		logFunc("Remote Desktop Client exited.")
+		return err
+	case err = <-client.failed:
+		// Reconnecting gave up (e.g. host key changed); take the RDP client down with it
+		cancel()
+		<-exited
+		return fmt.Errorf("SSH reconnect failed: %w", err)
+	}
 }
 
-func handleForward(client *ssh.Client, localConn net.Conn, logFunc func(string)) {
-	defer localConn.Close()
+// detachedIdleTimeout closes the tunnel of a detached RDP client once no forwarded
+// connection has been open for this long
+const detachedIdleTimeout = 30 * time.Second
 
-	remoteConn, err := client.Dial("tcp", "localhost:3389")
-	if err != nil {
-		logFunc(fmt.Sprintf("Failed to dial remote RDP port: %v", err))
-		return
+// forwardTracker counts open forwards, to follow RDP clients that run in another process
+type forwardTracker struct {
+	mu        sync.Mutex
+	active    int
+	idleSince time.Time
+}
+
+func (t *forwardTracker) begin() {
+	t.mu.Lock()
+	t.active++
+	t.mu.Unlock()
+}
+
+func (t *forwardTracker) end() {
+	t.mu.Lock()
+	t.active--
+	if t.active == 0 {
+		t.idleSince = time.Now()
 	}
-	defer remoteConn.Close()
+	t.mu.Unlock()
+}
 
-	logFunc(fmt.Sprintf("Accepted connection from %s", localConn.RemoteAddr()))
+func (t *forwardTracker) idle() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.active == 0 && time.Since(t.idleSince) >= detachedIdleTimeout
+}
 
-	copyConn := func(writer, reader net.Conn, direction string) {
-		written, _ := io.Copy(writer, reader)
-		logFunc(fmt.Sprintf("Tunnel connection closed (%s). Bytes: %d", direction, written))
+// waitIdle blocks until the tunnel has been unused for detachedIdleTimeout
+func (t *forwardTracker) waitIdle(ctx context.Context, failed <-chan error) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-failed:
+			return fmt.Errorf("SSH reconnect failed: %w", err)
+		case <-ticker.C:
+			if t.idle() {
+				return nil
+			}
+		}
 	}
+}
 
-	go copyConn(localConn, remoteConn, "Remote->Local")
-	copyConn(remoteConn, localConn, "Local->Remote")
+func handleForward(ctx context.Context, client *supervisedClient, localConn net.Conn, remoteTarget string, counter *ForwardCounter, logFunc func(string)) {
+	remoteConn, err := client.Dial(ctx, "tcp", remoteTarget)
+	if err != nil {
+		localConn.Close()
+		logFunc(fmt.Sprintf("Failed to dial remote RDP target %s: %v", remoteTarget, err))
+		return
+	}
+
+	logFunc(fmt.Sprintf("Accepted connection from %s", localConn.RemoteAddr()))
+	sent, received := relay(localConn, remoteConn, counter)
+	logFunc(fmt.Sprintf("Tunnel connection from %s closed. Sent %d, received %d bytes", localConn.RemoteAddr(), sent, received))
 }
 
 // ExportPrivateKey exports the private key in PEM format (PKCS#1 for RSA, SEC1 for ECDSA)
@@ -176,6 +340,13 @@ func ExportPrivateKey(key interface{}) ([]byte, error) {
 			Type:  "EC PRIVATE KEY",
 			Bytes: b,
 		}
+	case ed25519.PrivateKey:
+		// Ed25519 has no traditional PEM format; use OpenSSH's own
+		b, err := ssh.MarshalPrivateKey(k, "")
+		if err != nil {
+			return nil, err
+		}
+		pemBlock = b
 	default:
 		return nil, fmt.Errorf("unsupported key type: %T", key)
 	}
@@ -190,6 +361,8 @@ func ExportPublicKey(key interface{}) ([]byte, error) {
 		pubKey = &k.PublicKey
 	case *ecdsa.PrivateKey:
 		pubKey = &k.PublicKey
+	case ed25519.PrivateKey:
+		pubKey = k.Public()
 	default:
 		return nil, fmt.Errorf("unsupported key type: %T", key)
 	}
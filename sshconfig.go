@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxIncludeDepth matches OpenSSH's limit on nested Include directives
+const maxIncludeDepth = 16
+
+// SSHConfig is a parsed OpenSSH client configuration file (ssh_config)
+type SSHConfig struct {
+	entries []sshConfigEntry
+	hosts   []string // concrete (wildcard-free) Host aliases, in file order
+
+	// Unsupported lists ignored directives as "file:line: Directive"
+	Unsupported []string
+}
+
+// sshConfigEntry is one directive with the Host patterns it is conditional on
+type sshConfigEntry struct {
+	patterns []string // nil applies to every host
+	match    bool     // inside a Match block, which is not supported and never applies
+	name     string   // directive as written
+	key      string   // lower case
+	args     []string
+	pos      string
+}
+
+// SSHHostConfig is the configuration that applies to one host alias
+type SSHHostConfig struct {
+	Alias               string
+	HostName            string
+	User                string
+	Port                string
+	ProxyJump           []JumpHost
+	IdentityFiles       []string
+	Forwards            []Forward // LocalForward, RemoteForward and DynamicForward
+	ServerAliveInterval time.Duration
+
+	// Unsupported lists directives that matched the host but are ignored
+	Unsupported []string
+}
+
+// Address returns HostName:Port for dialing
+func (h *SSHHostConfig) Address() string {
+	if h.Port == "" || h.Port == "22" {
+		return h.HostName
+	}
+	return net.JoinHostPort(h.HostName, h.Port)
+}
+
+// sshConfigSupported are the directives Resolve understands
+var sshConfigSupported = map[string]bool{
+	"host":                true,
+	"hostname":            true,
+	"user":                true,
+	"port":                true,
+	"proxyjump":           true,
+	"identityfile":        true,
+	"localforward":        true,
+	"remoteforward":       true,
+	"dynamicforward":      true,
+	"serveraliveinterval": true,
+	"include":             true,
+	"match":               true,
+}
+
+// DefaultSSHConfigPath returns ~/.ssh/config
+func DefaultSSHConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// LoadSSHConfig parses path and the files it includes. A missing file yields an empty config.
+func LoadSSHConfig(path string) (*SSHConfig, error) {
+	c := &SSHConfig{}
+	if err := c.parseFile(path, nil, false, 0); err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *SSHConfig) parseFile(path string, patterns []string, match bool, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		pos := fmt.Sprintf("%s:%d", filepath.Base(path), n)
+		name, args, err := splitSSHConfigLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("%s: %w", pos, err)
+		}
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+
+		switch key {
+		case "host":
+			patterns, match = args, false
+			for _, p := range args {
+				if !strings.ContainsAny(p, "*?!") {
+					c.hosts = append(c.hosts, p)
+				}
+			}
+			continue
+		case "match":
+			// Match criteria (exec, user, ...) are not evaluated; the block is skipped
+			c.Unsupported = append(c.Unsupported, pos+": Match")
+			patterns, match = nil, true
+			continue
+		case "include":
+			if depth >= maxIncludeDepth {
+				return fmt.Errorf("%s: Include nested too deeply", pos)
+			}
+			for _, pattern := range args {
+				if err := c.include(pattern, patterns, match, depth+1); err != nil {
+					return fmt.Errorf("%s: %w", pos, err)
+				}
+			}
+			continue
+		}
+
+		if !sshConfigSupported[key] {
+			c.Unsupported = append(c.Unsupported, pos+": "+name)
+		}
+		c.entries = append(c.entries, sshConfigEntry{patterns: patterns, match: match, name: name, key: key, args: args, pos: pos})
+	}
+	return scanner.Err()
+}
+
+// include parses the files matching a glob; relative paths are taken from ~/.ssh
+func (c *SSHConfig) include(pattern string, patterns []string, match bool, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		pattern = filepath.Join(home, ".ssh", pattern)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("bad Include pattern %q: %w", pattern, err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		if err := c.parseFile(file, patterns, match, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSSHConfigLine splits "Keyword args" or "Keyword=args", honouring double quotes.
+// Blank lines and comments return an empty keyword.
+func splitSSHConfigLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return line, nil, nil
+	}
+	key := line[:end]
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+
+	var args []string
+	var cur strings.Builder
+	inQuote, inArg := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inArg = true
+		case !inQuote && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case !inQuote && r == '#' && !inArg:
+			// Trailing comment
+			return key, args, nil
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inQuote {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return key, args, nil
+}
+
+// Hosts returns the concrete Host aliases defined in the file, without wildcard patterns
+func (c *SSHConfig) Hosts() []string {
+	var hosts []string
+	seen := make(map[string]bool)
+	for _, h := range c.hosts {
+		if !seen[h] {
+			seen[h] = true
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// Resolve computes the settings for alias. As in OpenSSH, the first value obtained for a
+// directive wins, except IdentityFile and the forwards which accumulate.
+func (c *SSHConfig) Resolve(alias string) (*SSHHostConfig, error) {
+	return c.resolve(alias, 0)
+}
+
+func (c *SSHConfig) resolve(alias string, depth int) (*SSHHostConfig, error) {
+	h := &SSHHostConfig{Alias: alias}
+	var proxyJump string
+	seen := make(map[string]bool)
+
+	for _, e := range c.entries {
+		if e.match || (e.patterns != nil && !matchSSHConfigHost(e.patterns, alias)) {
+			continue
+		}
+		if !sshConfigSupported[e.key] {
+			h.Unsupported = append(h.Unsupported, e.pos+": "+e.name)
+			continue
+		}
+		if len(e.args) == 0 {
+			return nil, fmt.Errorf("%s: %s needs an argument", e.pos, e.name)
+		}
+
+		switch e.key {
+		case "identityfile":
+			h.IdentityFiles = append(h.IdentityFiles, e.args[0])
+			continue
+		case "localforward", "remoteforward", "dynamicforward":
+			f, err := sshConfigForward(e.key, e.args)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", e.pos, err)
+			}
+			h.Forwards = append(h.Forwards, f)
+			continue
+		}
+		if seen[e.key] {
+			continue
+		}
+		seen[e.key] = true
+
+		switch e.key {
+		case "hostname":
+			h.HostName = e.args[0]
+		case "user":
+			h.User = e.args[0]
+		case "port":
+			if _, err := strconv.ParseUint(e.args[0], 10, 16); err != nil {
+				return nil, fmt.Errorf("%s: invalid Port %q", e.pos, e.args[0])
+			}
+			h.Port = e.args[0]
+		case "proxyjump":
+			proxyJump = e.args[0]
+		case "serveraliveinterval":
+			secs, err := strconv.Atoi(e.args[0])
+			if err != nil || secs < 0 {
+				return nil, fmt.Errorf("%s: invalid ServerAliveInterval %q", e.pos, e.args[0])
+			}
+			h.ServerAliveInterval = time.Duration(secs) * time.Second
+		}
+	}
+
+	// %h in HostName refers to the alias
+	hostName := h.HostName
+	h.HostName = alias
+	if hostName != "" {
+		h.HostName = expandSSHTokens(hostName, alias, h)
+	}
+	for i, file := range h.IdentityFiles {
+		h.IdentityFiles[i] = expandHome(expandSSHTokens(file, alias, h))
+	}
+
+	if proxyJump != "" && !strings.EqualFold(proxyJump, "none") {
+		hops, err := c.resolveJumps(proxyJump, depth)
+		if err != nil {
+			return nil, err
+		}
+		h.ProxyJump = hops
+	}
+	return h, nil
+}
+
+// resolveJumps turns a ProxyJump list into hops. Each hop may itself be an alias with its
+// own HostName, User, Port, IdentityFile and ProxyJump.
+func (c *SSHConfig) resolveJumps(spec string, depth int) ([]JumpHost, error) {
+	if depth >= maxIncludeDepth {
+		return nil, fmt.Errorf("ProxyJump chain for %q is too long or loops", spec)
+	}
+
+	var hops []JumpHost
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimPrefix(strings.TrimSpace(part), "ssh://")
+		hop, err := ParseJumpHost(part)
+		if err != nil {
+			return nil, err
+		}
+
+		jh, err := c.resolve(hop.Host, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, jh.ProxyJump...)
+
+		if hop.User == "" {
+			hop.User = jh.User
+		}
+		if hop.Port == "" {
+			hop.Port = jh.Port
+		}
+		hop.Host = jh.HostName
+		if len(jh.IdentityFiles) > 0 {
+			hop.KeyPath = jh.IdentityFiles[0]
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// sshConfigForward converts "LocalForward [bind:]port host:hostport" and its Remote and
+// Dynamic variants into a Forward
+func sshConfigForward(key string, args []string) (Forward, error) {
+	kind := map[string]string{"localforward": ForwardLocal, "remoteforward": ForwardRemote, "dynamicforward": ForwardDynamic}[key]
+	if kind == ForwardDynamic && len(args) != 1 || kind != ForwardDynamic && len(args) != 2 {
+		return Forward{}, fmt.Errorf("invalid %s %q", key, strings.Join(args, " "))
+	}
+	return ParseForward(kind + " " + strings.Join(args, ":"))
+}
+
+// matchSSHConfigHost applies Host patterns: any negated match excludes the host
+func matchSSHConfigHost(patterns []string, host string) bool {
+	matched := false
+	for _, p := range patterns {
+		negate := strings.HasPrefix(p, "!")
+		if !matchHostPattern(strings.TrimPrefix(p, "!"), host) {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// expandSSHTokens replaces the ssh_config tokens %h, %n, %p, %r, %u and %%
+func expandSSHTokens(s, alias string, h *SSHHostConfig) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	port := h.Port
+	if port == "" {
+		port = "22"
+	}
+	local := os.Getenv("USER")
+	if local == "" {
+		local = os.Getenv("USERNAME")
+	}
+	return strings.NewReplacer("%%", "%", "%h", h.HostName, "%n", alias, "%p", port, "%r", h.User, "%u", local).Replace(s)
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// ProfileFromSSHConfig creates a profile for a Host alias. With live set the profile keeps
+// the alias and resolves it on each connect (Profile.UseSSHConfig); otherwise the resolved
+// settings are copied. Returned notes describe settings that were not carried over.
+func (c *SSHConfig) ProfileFromSSHConfig(alias, name string, live bool) (*Profile, []string, error) {
+	h, err := c.Resolve(alias)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := newProfile(name)
+	if live {
+		p.RemoteHost = alias
+		p.UseSSHConfig = true
+	} else {
+		p.RemoteHost = h.Address()
+		p.RemoteUser = h.User
+		p.JumpHosts = h.ProxyJump
+		p.Forwards = h.Forwards
+	}
+
+	var notes []string
+	if len(h.IdentityFiles) > 0 {
+		notes = append(notes, fmt.Sprintf("IdentityFile %s not used for the target; it authenticates with the P12 certificate", strings.Join(h.IdentityFiles, ", ")))
+	}
+	if h.ServerAliveInterval > 0 && !live {
+		notes = append(notes, fmt.Sprintf("ServerAliveInterval %s only applies when the profile resolves the host from ~/.ssh/config", h.ServerAliveInterval))
+	}
+	for _, u := range h.Unsupported {
+		notes = append(notes, "Unsupported directive "+u)
+	}
+	return p, notes, nil
+}
+
+// applySSHConfig resolves opts.Host as a Host alias from ~/.ssh/config, re-reading the
+// file so edits apply on the next connect. Settings made in the profile win over the file.
+// The host's forwards are returned for the tunnel.
+func applySSHConfig(opts ConnectOptions, logFunc func(string)) (ConnectOptions, []Forward, error) {
+	path, err := DefaultSSHConfigPath()
+	if err != nil {
+		return opts, nil, err
+	}
+	c, err := LoadSSHConfig(path)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	h, err := c.Resolve(opts.Host)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to resolve %s from %s: %w", opts.Host, path, err)
+	}
+
+	opts.Host = h.Address()
+	if opts.User == "" {
+		opts.User = h.User
+	}
+	if len(opts.JumpHosts) == 0 {
+		opts.JumpHosts = h.ProxyJump
+	}
+	if h.ServerAliveInterval > 0 {
+		opts.KeepAliveInterval = h.ServerAliveInterval
+	}
+	opts.UseSSHConfig = false
+
+	if opts.User == "" {
+		return opts, nil, fmt.Errorf("no SSH username for %s in the profile or %s", h.Alias, path)
+	}
+
+	via := "direct"
+	if len(opts.JumpHosts) > 0 {
+		var names []string
+		for _, hop := range opts.JumpHosts {
+			names = append(names, hop.String())
+		}
+		via = "via " + strings.Join(names, " -> ")
+	}
+	logFunc(fmt.Sprintf("Resolved %s from ssh config: %s as %s (%s)", h.Alias, opts.Host, opts.User, via))
+	for _, u := range h.Unsupported {
+		logFunc("  Ignoring unsupported directive " + u)
+	}
+	return opts, h.Forwards, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultUserCAValidity is used when the config does not set user_ca_validity
+const DefaultUserCAValidity = 8 * time.Hour
+
+// certClockSkew backdates minted certificates so small clock differences don't reject them
+const certClockSkew = 5 * time.Minute
+
+// ErrCAPassphraseRequired is returned by LoadCAKey when the key is encrypted and no passphrase was given
+var ErrCAPassphraseRequired = errors.New("CA key is passphrase protected")
+
+// LoadCAKey reads an OpenSSH or PEM private key used to sign user certificates
+func LoadCAKey(path string, passphrase []byte) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+
+	var signer ssh.Signer
+	if len(passphrase) > 0 {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	} else {
+		signer, err = ssh.ParsePrivateKey(data)
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, ErrCAPassphraseRequired
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	return signer, nil
+}
+
+// ParseCertValidity parses a validity such as "8h" or "30m", falling back to the default
+func ParseCertValidity(s string) (time.Duration, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultUserCAValidity, nil
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid certificate validity %q: %w", s, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("certificate validity must be positive")
+	}
+	return d, nil
+}
+
+// CertPrincipals derives SSH principals from the X.509 identity: the UPN, its
+// local part, and the certificate CommonName
+func CertPrincipals(info *P12Info) []string {
+	var principals []string
+	seen := make(map[string]bool)
+	add := func(p string) {
+		p = strings.TrimSpace(p)
+		if p != "" && !seen[p] {
+			seen[p] = true
+			principals = append(principals, p)
+		}
+	}
+
+	add(info.UPN)
+	if at := strings.Index(info.UPN, "@"); at > 0 {
+		add(info.UPN[:at])
+	}
+	add(info.CommonName)
+	return principals
+}
+
+// MintUserCertificate issues a short-lived OpenSSH user certificate for the P12
+// public key, signed by the local CA. user, the SSH username a profile's rule derived
+// from the same certificate, is added to the principals when set.
+func MintUserCertificate(ca ssh.Signer, info *P12Info, user string, validity time.Duration) (*ssh.Certificate, error) {
+	signer, err := ssh.NewSignerFromKey(info.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer from private key: %w", err)
+	}
+
+	principals := CertPrincipals(info)
+	if user != "" && !slices.Contains(principals, user) {
+		principals = append(principals, user)
+	}
+	if len(principals) == 0 {
+		return nil, fmt.Errorf("certificate has no UPN or CommonName to use as principal")
+	}
+
+	var serial [8]byte
+	if _, err := rand.Read(serial[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate serial: %w", err)
+	}
+
+	now := time.Now()
+	cert := &ssh.Certificate{
+		Key:             signer.PublicKey(),
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%s:%s:%s", strings.ToLower(AppName), info.CommonName, info.Certificate.SerialNumber.Text(16)),
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-certClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions: ssh.Permissions{
+			// Same defaults as ssh-keygen -s
+			Extensions: map[string]string{
+				"permit-X11-forwarding":   "",
+				"permit-agent-forwarding": "",
+				"permit-port-forwarding":  "",
+				"permit-pty":              "",
+				"permit-user-rc":          "",
+			},
+		},
+	}
+
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		return nil, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// A username rule can derive a login (here the email local part) that is none of the
+// default principals; the minted certificate must still cover it
+func TestResolveUserCertificatePrincipals(t *testing.T) {
+	cert, key := newTestCert(t, "John Doe", false, nil, nil)
+	cert.EmailAddresses = []string{"john.doe@corp.example.com"}
+	info := &P12Info{PrivateKey: key, Certificate: cert, CommonName: "John Doe", UPN: "jdoe@corp.example.com"}
+
+	_, caKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{UserCAKeyPath: "ca"}
+	loadCA := func() (ssh.Signer, error) { return ca, nil }
+
+	tests := []struct {
+		name string
+		p    *Profile
+		want []string
+	}{
+		{"manual", &Profile{RemoteUser: "admin"}, []string{"jdoe@corp.example.com", "jdoe", "John Doe"}},
+		{"upn user", &Profile{UsernameRule: UsernameFromUPNUser}, []string{"jdoe@corp.example.com", "jdoe", "John Doe"}},
+		{"email user", &Profile{UsernameRule: UsernameFromEmailUser}, []string{"jdoe@corp.example.com", "jdoe", "John Doe", "john.doe"}},
+		{"regex", &Profile{UsernameRule: UsernameFromRegex, UsernameRegex: `CN=John (\w+)`}, []string{"jdoe@corp.example.com", "jdoe", "John Doe", "Doe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolveUserCertificate(cfg, tt.p, info, loadCA, func(string) {})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.ValidPrincipals, tt.want) {
+				t.Errorf("principals = %q, want %q", got.ValidPrincipals, tt.want)
+			}
+		})
+	}
+}
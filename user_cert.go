@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NewSigner builds the SSH signer for the P12 private key. When userCert is set the
+// signer presents the OpenSSH certificate instead of the bare public key.
+func NewSigner(p12Info *P12Info, userCert *ssh.Certificate) (ssh.Signer, error) {
+	signer, err := ssh.NewSignerFromKey(p12Info.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer from private key: %w", err)
+	}
+	if userCert == nil {
+		return signer, nil
+	}
+
+	certSigner, err := ssh.NewCertSigner(userCert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate signer: %w", err)
+	}
+	return certSigner, nil
+}
+
+// LoadUserCertificate reads an OpenSSH user certificate (id_*-cert.pub) and checks
+// that it certifies the P12 private key
+func LoadUserCertificate(path string, p12Info *P12Info) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is a plain %s public key, not a certificate", path, pub.Type())
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is a host certificate, not a user certificate", path)
+	}
+
+	signer, err := ssh.NewSignerFromKey(p12Info.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signer from private key: %w", err)
+	}
+	if !bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		return nil, fmt.Errorf("certificate key %s does not match the P12 key %s",
+			ssh.FingerprintSHA256(cert.Key), ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+
+	return cert, nil
+}
+
+// ResolveUserCertificate returns the OpenSSH certificate to present for the P12 key:
+// minted by the local user CA when one is configured, else read from the profile's
+// certificate file, else nil. loadCA is only called when a CA is configured. A minted
+// certificate also names the user p's username rule derives, so the login matches it.
+func ResolveUserCertificate(cfg *Config, p *Profile, info *P12Info, loadCA func() (ssh.Signer, error), logFunc func(string)) (*ssh.Certificate, error) {
+	if cfg.UserCAKeyPath != "" {
+		validity, err := ParseCertValidity(cfg.UserCAValidity)
+		if err != nil {
+			return nil, err
+		}
+		var user string
+		if p.UsernameRule != UsernameManual {
+			if user, err = p.SSHUser(info); err != nil {
+				return nil, err
+			}
+		}
+		ca, err := loadCA()
+		if err != nil {
+			return nil, err
+		}
+		cert, err := MintUserCertificate(ca, info, user, validity)
+		if err != nil {
+			return nil, err
+		}
+
+		logFunc("SSH User Certificate Minted by local CA:")
+		for _, line := range DescribeUserCertificate(cert) {
+			logFunc(line)
+		}
+		return cert, nil
+	}
+
+	if p.UserCertPath == "" {
+		return nil, nil
+	}
+
+	cert, err := LoadUserCertificate(p.UserCertPath, info)
+	if err != nil {
+		return nil, err
+	}
+
+	logFunc(fmt.Sprintf("SSH User Certificate Loaded: %s", filepath.Base(p.UserCertPath)))
+	for _, line := range DescribeUserCertificate(cert) {
+		logFunc(line)
+	}
+	return cert, nil
+}
+
+// DescribeUserCertificate returns log lines summarising an OpenSSH user certificate
+func DescribeUserCertificate(cert *ssh.Certificate) []string {
+	principals := "(any)"
+	if len(cert.ValidPrincipals) > 0 {
+		principals = strings.Join(cert.ValidPrincipals, ", ")
+	}
+
+	lines := []string{
+		fmt.Sprintf("  Key ID: %s", cert.KeyId),
+		fmt.Sprintf("  Serial: %d", cert.Serial),
+		fmt.Sprintf("  Principals: %s", principals),
+		fmt.Sprintf("  Valid: %s to %s", certTime(cert.ValidAfter), certTime(cert.ValidBefore)),
+		fmt.Sprintf("  Signed by CA: %s %s", cert.SignatureKey.Type(), ssh.FingerprintSHA256(cert.SignatureKey)),
+	}
+
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter {
+		lines = append(lines, "  WARNING: certificate is not yet valid")
+	} else if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		lines = append(lines, "  WARNING: certificate has expired")
+	}
+	return lines
+}
+
+func certTime(t uint64) string {
+	if t == ssh.CertTimeInfinity {
+		return "forever"
+	}
+	if t == 0 {
+		return "always"
+	}
+	return time.Unix(int64(t), 0).Format("2006-01-02 15:04:05")
+}
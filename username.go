@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Username rules derive a profile's SSH (and RDP) username from the P12 certificate
+const (
+	UsernameManual        = ""           // the profile's RemoteUser
+	UsernameFromUPN       = "upn"        // the whole UPN, e.g. jdoe@corp.example.com
+	UsernameFromUPNUser   = "upn-user"   // the UPN before the @, e.g. jdoe
+	UsernameFromEmailUser = "email-user" // the first email address before the @
+	UsernameFromCN        = "cn"         // the subject CommonName
+	UsernameFromRegex     = "regex"      // Profile.UsernameRegex matched against the subject DN
+)
+
+// UsernameRules lists the rules in display order with their labels
+var UsernameRules = []struct {
+	Rule  string
+	Label string
+}{
+	{UsernameManual, "Entered manually"},
+	{UsernameFromUPN, "UPN"},
+	{UsernameFromUPNUser, "UPN user part"},
+	{UsernameFromEmailUser, "Email local part"},
+	{UsernameFromCN, "Common Name"},
+	{UsernameFromRegex, "Regex over subject"},
+}
+
+// UsernameRuleLabel returns the display label for rule
+func UsernameRuleLabel(rule string) string {
+	for _, r := range UsernameRules {
+		if r.Rule == rule {
+			return r.Label
+		}
+	}
+	return rule
+}
+
+// ValidateUsernameRule checks rule is known and, for UsernameFromRegex, that pattern compiles
+func ValidateUsernameRule(rule, pattern string) error {
+	for _, r := range UsernameRules {
+		if r.Rule != rule {
+			continue
+		}
+		if rule == UsernameFromRegex {
+			if strings.TrimSpace(pattern) == "" {
+				return fmt.Errorf("a regular expression is required")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid username regex: %w", err)
+			}
+			if re.NumSubexp() > 1 {
+				return fmt.Errorf("username regex must have at most one capture group")
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown username rule %q", rule)
+}
+
+// DeriveUsername applies rule to the certificate. The subject DN for UsernameFromRegex is
+// in RFC 2253 form, e.g. "CN=John Doe,OU=Users,O=Corp"; the capture group, or the whole
+// match without one, is the username.
+func DeriveUsername(rule, pattern string, info *P12Info) (string, error) {
+	cert := info.Certificate
+	localPart := func(addr string) string {
+		if at := strings.LastIndex(addr, "@"); at >= 0 {
+			return addr[:at]
+		}
+		return addr
+	}
+
+	var user, what string
+	switch rule {
+	case UsernameFromUPN:
+		user, what = info.UPN, "UPN"
+	case UsernameFromUPNUser:
+		user, what = localPart(info.UPN), "UPN"
+	case UsernameFromEmailUser:
+		what = "email address"
+		if len(cert.EmailAddresses) > 0 {
+			user = localPart(cert.EmailAddresses[0])
+		}
+	case UsernameFromCN:
+		user, what = cert.Subject.CommonName, "Common Name"
+	case UsernameFromRegex:
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid username regex: %w", err)
+		}
+		what = fmt.Sprintf("match for %s in %q", pattern, cert.Subject.String())
+		if m := re.FindStringSubmatch(cert.Subject.String()); m != nil {
+			user = m[len(m)-1]
+		}
+	default:
+		return "", fmt.Errorf("unknown username rule %q", rule)
+	}
+
+	user = strings.TrimSpace(user)
+	if user == "" {
+		return "", fmt.Errorf("cannot derive the SSH username: certificate has no %s", what)
+	}
+	if strings.IndexFunc(user, unicode.IsControl) >= 0 {
+		return "", fmt.Errorf("cannot derive the SSH username: %q contains control characters", user)
+	}
+	return user, nil
+}
+
+// SSHUser returns the username to connect as: RemoteUser, or the one the profile's
+// rule derives from info
+func (p *Profile) SSHUser(info *P12Info) (string, error) {
+	if p.UsernameRule == UsernameManual {
+		return p.RemoteUser, nil
+	}
+	if info == nil {
+		return "", fmt.Errorf("the username rule %q needs the P12 certificate", UsernameRuleLabel(p.UsernameRule))
+	}
+	return DeriveUsername(p.UsernameRule, p.UsernameRegex, info)
+}
@@ -16,7 +16,8 @@ var (
 	IconPathDisconnected = "icons/disconnected.png"
 
 	// Status Text
-	StatusTextConnected    = "Status: Connected to %s"
+	StatusTextConnected    = "Status: Connected to %s via %s (localhost:%s)"
+	StatusTextTunnelOnly   = "Status: Tunnel open at %s → %s via %s"
 	StatusTextDisconnected = "Status: Disconnected"
 
 	// About Text